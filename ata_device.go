@@ -1,8 +1,13 @@
 package melcloud
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -10,42 +15,251 @@ import (
 // This combines fields from the base device state and ATA specific ones.
 type AtaDeviceState struct {
 	// Base device fields (subset also available in the main GET response)
-	DeviceID          int     `json:"DeviceID"`
-	BuildingID        int     `json:"BuildingID"` // Note: Not always in Get response, use from Device struct
-	MacAddress        string  `json:"MacAddress"`
-	SerialNumber      string  `json:"SerialNumber"`
-	DeviceType        int     `json:"DeviceType"` // 0 for ATA
-	Power             bool    `json:"Power"`
-	RoomTemperature   float64 `json:"RoomTemperature"`
-	SetTemperature    float64 `json:"SetTemperature"`
-	OperationMode     int     `json:"OperationMode"` // 1:Heat, 2:Dry, 3:Cool, 7:Fan, 8:Auto
-	SetFanSpeed       int     `json:"SetFanSpeed"`   // 0:Auto, 1-N: Speeds
-	VaneHorizontal    int     `json:"VaneHorizontal"`
-	VaneVertical      int     `json:"VaneVertical"`
-	ErrorCode         int     `json:"ErrorCode"`
-	HasError          bool    `json:"HasError"`
-	LastCommunication string  `json:"LastCommunication"` // ISO 8601 format "YYYY-MM-DDTHH:MM:SS.ffffff"
-	EffectiveFlags    int     `json:"EffectiveFlags"`    // Crucial for setting state
-	HasPendingCommand bool    `json:"HasPendingCommand"` // Crucial for setting state
+	DeviceID                     int     `json:"DeviceID"`
+	BuildingID                   int     `json:"BuildingID"`           // Note: Not always in Get response, use from Device struct
+	DeviceName                   string  `json:"DeviceName,omitempty"` // Not in Get response, filled in from ListDevices when known; never sent
+	MacAddress                   string  `json:"MacAddress"`
+	SerialNumber                 string  `json:"SerialNumber"`
+	DeviceType                   int     `json:"DeviceType"` // 0 for ATA
+	Power                        bool    `json:"Power"`
+	StandbyMode                  bool    `json:"InStandbyMode"` // Low-power standby while Power is on (read-only)
+	RoomTemperature              float64 `json:"RoomTemperature"`
+	SetTemperature               float64 `json:"SetTemperature"`
+	DefaultHeatingSetTemperature float64 `json:"DefaultHeatingSetTemperature"`
+	DefaultCoolingSetTemperature float64 `json:"DefaultCoolingSetTemperature"`
+	OperationMode                int     `json:"OperationMode"` // 1:Heat, 2:Dry, 3:Cool, 7:Fan, 8:Auto
+	SetFanSpeed                  int     `json:"SetFanSpeed"`   // 0:Auto, 1-N: Speeds
+	VaneHorizontal               int     `json:"VaneHorizontal"`
+	VaneVertical                 int     `json:"VaneVertical"`
+	ErrorCode                    int     `json:"ErrorCode"`
+	HasError                     bool    `json:"HasError"`
+	LastCommunication            string  `json:"LastCommunication"` // ISO 8601 format "YYYY-MM-DDTHH:MM:SS.ffffff"
+	EffectiveFlags               int     `json:"EffectiveFlags"`    // Crucial for setting state
+	HasPendingCommand            bool    `json:"HasPendingCommand"` // Crucial for setting state
+	DemandPercentage             int     `json:"DemandPercentage"`  // Current demand as a percentage of capacity
+
+	// Capacity limit set with SetDemandPercentage, as a percentage of rated capacity
+	// (100 = unrestricted); DemandPercentage is the actual demand within that limit
+	DemandLimit int `json:"DemandLimit"`
+
+	// Last heartbeat of the WiFi adapter, in the LastCommunication format. Heartbeats only show
+	// the adapter is connected; commands are delivered with state communication, see IsOffline.
+	LastSeen string `json:"LastSeen"`
+
+	// All active faults, only on units that can report several at once; see Faults
+	ErrorCodes []int `json:"ErrorCodes,omitempty"`
+
+	// Transient, read-only: the unit is switching operation mode and may not reflect commands yet
+	ModeChanging bool `json:"InModeChange"`
+
+	// Separate heating/cooling setpoints in heat_cool mode, only on units with dual setpoints
+	HeatSetTemperature float64 `json:"HeatSetTemperature"`
+	CoolSetTemperature float64 `json:"CoolSetTemperature"`
+
+	// Room temperature at which heat_cool mode switches between heating and cooling,
+	// only on units that let it be configured (zero when absent)
+	ChangeoverTemperature float64 `json:"AutoChangeoverTemperature"`
+
+	// Night (quiet) mode limits the outdoor unit's fan and compressor noise, on units that support it
+	NightMode bool `json:"NightMode"`
+
+	// Readings only reported by some units (zero when absent)
+	OutdoorTemperature float64 `json:"OutdoorTemperature"`
+	WifiSignalStrength int     `json:"WifiSignalStrength"` // dBm
+	Offline            bool    `json:"Offline"`            // Adapter link to MELCloud is down
+	OperatingHours     float64 `json:"OperatingHours"`     // Cumulative compressor runtime counter
+	DefrostMode        int     `json:"DefrostMode"`        // Non-zero while the outdoor unit defrosts or preheats
+
+	// Read-only: a grid operator's demand-side management (DSM) signal is curtailing the unit,
+	// which may then ignore commands; see UnderGridControl
+	GridControl bool `json:"DemandSideControl"`
+
+	// Compressor operating frequency in Hz, nil unless the unit reports it; see CompressorFrequency
+	CompressorFrequencyHz *int `json:"CompressorFrequency,omitempty"`
+
+	// Holiday mode; dates use the LastCommunication format and are null when not set
+	HolidayMode          bool   `json:"HolidayMode"`
+	HolidayModeStartDate string `json:"HolidayModeStartDate"`
+	HolidayModeEndDate   string `json:"HolidayModeEndDate"`
+
+	raw json.RawMessage // Response JSON, kept with WithPreserveUnknownFields
+
+	// A setpoint set with SetTargetTemperature that has not been sent yet. In fan-only mode
+	// the flag is held back; SetOperationMode restores it when switching to another mode.
+	setpointPending bool
 
 	// Add other fields observed in API responses or pymelcloud as needed
-	// e.g., OutdoorTemperature, NumberOfFanSpeeds, ActualFanSpeed etc.
+	// e.g., NumberOfFanSpeeds, ActualFanSpeed etc.
+}
+
+// ErrNoCommunication is returned by LastCommunicationTime for devices that have never
+// communicated with MELCloud, e.g. newly provisioned units.
+var ErrNoCommunication = errors.New("device has never communicated")
+
+// UnmarshalJSON decodes a state, accepting integral floats (e.g. "SetFanSpeed": 2.0)
+// for the integer settings MELCloud sometimes sends as floats.
+func (s *AtaDeviceState) UnmarshalJSON(data []byte) error {
+	type plain AtaDeviceState // Avoids recursing into UnmarshalJSON
+	aux := struct {
+		*plain
+		OperationMode    flexInt `json:"OperationMode"`
+		SetFanSpeed      flexInt `json:"SetFanSpeed"`
+		VaneHorizontal   flexInt `json:"VaneHorizontal"`
+		VaneVertical     flexInt `json:"VaneVertical"`
+		DemandPercentage flexInt `json:"DemandPercentage"`
+		DemandLimit      flexInt `json:"DemandLimit"`
+
+		CompressorFrequency *flexInt `json:"CompressorFrequency"`
+	}{
+		plain:            (*plain)(s),
+		OperationMode:    flexInt(s.OperationMode),
+		SetFanSpeed:      flexInt(s.SetFanSpeed),
+		VaneHorizontal:   flexInt(s.VaneHorizontal),
+		VaneVertical:     flexInt(s.VaneVertical),
+		DemandPercentage: flexInt(s.DemandPercentage),
+		DemandLimit:      flexInt(s.DemandLimit),
+	}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	s.OperationMode = int(aux.OperationMode)
+	s.SetFanSpeed = int(aux.SetFanSpeed)
+	s.VaneHorizontal = int(aux.VaneHorizontal)
+	s.VaneVertical = int(aux.VaneVertical)
+	s.DemandPercentage = int(aux.DemandPercentage)
+	s.DemandLimit = int(aux.DemandLimit)
+	if aux.CompressorFrequency != nil {
+		hz := int(*aux.CompressorFrequency)
+		s.CompressorFrequencyHz = &hz
+	}
+	return nil
+}
+
+// CompressorFrequency returns the outdoor unit's compressor frequency in Hz, and false if
+// the unit does not report it. A reported 0 means the compressor is stopped.
+func (s *AtaDeviceState) CompressorFrequency() (int, bool) {
+	if s.CompressorFrequencyHz == nil {
+		return 0, false
+	}
+	return *s.CompressorFrequencyHz, true
 }
 
 // LastCommunicationTime parses the LastCommunication string into a time.Time object.
+// It returns ErrNoCommunication when MELCloud reports no communication yet, as an empty
+// value or the .NET minimum date "0001-01-01T00:00:00".
 func (s *AtaDeviceState) LastCommunicationTime() (time.Time, error) {
+	if s.LastCommunication == "" {
+		return time.Time{}, ErrNoCommunication
+	}
+	t, err := parseTimestamp(s.LastCommunication)
+	if err == nil && t.Year() <= 1 {
+		return time.Time{}, ErrNoCommunication
+	}
+	return t, err
+}
+
+// parseTimestamp parses a MELCloud timestamp such as "2024-01-02T15:04:05.123456".
+func parseTimestamp(value string) (time.Time, error) {
 	// MELCloud uses a specific format, sometimes with 6 or 7 fractional digits
 	// We need to handle potential variations
 	layout := "2006-01-02T15:04:05.000000"
-	if len(s.LastCommunication) > len(layout) {
+	if len(value) > len(layout) {
 		// Adjust layout if more precision is present (e.g., .1234567)
 		layout += "Z" // Assuming UTC if timezone not specified, adjust if needed
-		return time.Parse(layout[:len(s.LastCommunication)], s.LastCommunication)
-	} else if len(s.LastCommunication) < len(layout) {
+		return time.Parse(layout[:len(value)], value)
+	} else if len(value) < len(layout) {
 		// Adjust layout if less precision is present
-		return time.Parse(layout[:len(s.LastCommunication)], s.LastCommunication)
+		return time.Parse(layout[:len(value)], value)
+	}
+	return time.Parse(layout, value)
+}
+
+// IsDefrosting reports whether the outdoor unit is defrosting (or preheating after a
+// defrost). Heating pauses meanwhile, so the room may cool down although Power is on
+// in heat mode. Always false for units that don't report DefrostMode.
+func (s *AtaDeviceState) IsDefrosting() bool {
+	return s.DefrostMode != 0
+}
+
+// RuntimeBetween returns how long the unit ran between two states of the same device,
+// from their OperatingHours counters, e.g. to schedule maintenance every N hours.
+// ok is false if either state has no counter or the counter went backwards (reset or
+// replaced board).
+func RuntimeBetween(earlier, later *AtaDeviceState) (runtime time.Duration, ok bool) {
+	if earlier.OperatingHours == 0 || later.OperatingHours == 0 || later.OperatingHours < earlier.OperatingHours {
+		return 0, false
+	}
+	return time.Duration((later.OperatingHours - earlier.OperatingHours) * float64(time.Hour)), true
+}
+
+// LastSeenTime parses the adapter's last heartbeat. Like LastCommunicationTime, it returns
+// ErrNoCommunication when none is reported.
+func (s *AtaDeviceState) LastSeenTime() (time.Time, error) {
+	if s.LastSeen == "" {
+		return time.Time{}, ErrNoCommunication
+	}
+	t, err := parseTimestamp(s.LastSeen)
+	if err == nil && t.Year() <= 1 {
+		return time.Time{}, ErrNoCommunication
+	}
+	return t, err
+}
+
+// IsOffline reports whether commands are unlikely to reach the unit at time now: MELCloud
+// flags the adapter as offline, or the last state communication is older than maxAge.
+// LastCommunication, not the LastSeen heartbeat, decides: an adapter can keep sending
+// heartbeats while its state exchange (which carries commands) has stalled.
+func (s *AtaDeviceState) IsOffline(now time.Time, maxAge time.Duration) bool {
+	if s.Offline {
+		return true
+	}
+	last, err := s.LastCommunicationTime()
+	return err != nil || now.Sub(last) > maxAge
+}
+
+// Transitioning reports whether the unit is in standby or in the middle of a mode change.
+// Settings sent meanwhile may only take effect once the transition completes.
+func (s *AtaDeviceState) Transitioning() bool {
+	return s.StandbyMode || s.ModeChanging
+}
+
+// UnderGridControl reports whether a demand-response program currently curtails the unit.
+// Commands may not take effect until the grid signal ends; MELCloud offers no opt-out
+// through this API.
+func (s *AtaDeviceState) UnderGridControl() bool {
+	return s.GridControl
+}
+
+// HolidayModeActive reports whether holiday mode is enabled on the unit.
+func (s *AtaDeviceState) HolidayModeActive() bool {
+	return s.HolidayMode
+}
+
+// HolidayModePeriod returns the configured holiday period, e.g. to show "away until March 3".
+// Either time is zero when it is not set or cannot be parsed.
+func (s *AtaDeviceState) HolidayModePeriod() (start, end time.Time) {
+	if s.HolidayModeStartDate != "" {
+		start, _ = parseTimestamp(s.HolidayModeStartDate)
+	}
+	if s.HolidayModeEndDate != "" {
+		end, _ = parseTimestamp(s.HolidayModeEndDate)
+	}
+	return start, end
+}
+
+// PowerState returns the three-way power state ("on", "standby" or "off").
+// Standby is reported by the unit itself and cannot be commanded directly;
+// MELCloud ignores InStandbyMode in SetAta requests, so use SetPower to move
+// between on and off.
+func (s *AtaDeviceState) PowerState() string {
+	switch {
+	case !s.Power:
+		return PowerOff
+	case s.StandbyMode:
+		return PowerStandby
+	default:
+		return PowerOn
 	}
-	return time.Parse(layout, s.LastCommunication)
 }
 
 // Constants for ATA device properties
@@ -57,6 +271,11 @@ const (
 	FlagFanSpeed       = 0x08
 	FlagVaneVertical   = 0x10
 	FlagVaneHorizontal = 0x100
+	FlagDemandPercent  = 0x800
+	FlagHeatSetTemp    = 0x1000 // Heating setpoint in heat_cool mode (dual setpoint units)
+	FlagCoolSetTemp    = 0x2000 // Cooling setpoint in heat_cool mode (dual setpoint units)
+	FlagChangeover     = 0x4000 // Heat/cool changeover temperature in heat_cool mode
+	FlagNightMode      = 0x8000 // Quiet outdoor unit operation
 
 	// Operation Modes (int)
 	OpModeHeat     = 1
@@ -100,6 +319,10 @@ const (
 	ModeHeatCool = "heat_cool"
 	ModeUnknown  = "unknown"
 
+	PowerOn      = "on"
+	PowerStandby = "standby"
+	PowerOff     = "off"
+
 	FanAuto = "auto"
 
 	VaneAuto  = "auto"
@@ -138,28 +361,209 @@ func (s *AtaDeviceState) SetPower(power bool) {
 }
 
 // SetOperationMode updates the OperationMode from a string representation and sets the flag.
+// Switching to fan-only mode holds back a pending target temperature change, since the
+// setpoint has no meaning in that mode; switching to another mode sends it again.
 // Returns an error if the mode string is invalid.
 func (s *AtaDeviceState) SetOperationMode(mode string) error {
 	if modeInt, ok := opModeStringToInt[mode]; ok {
 		s.OperationMode = modeInt
 		s.EffectiveFlags |= FlagOperationMode
+		switch {
+		case !s.TemperatureControllable():
+			s.EffectiveFlags &^= FlagTargetTemp
+		case s.setpointPending:
+			s.EffectiveFlags |= FlagTargetTemp
+		}
 		return nil
 	}
 	return fmt.Errorf("invalid operation mode: %s", mode)
 }
 
+// SetOperationModeChecked is like SetOperationMode but rejects modes the device does not
+// support (see Device.SupportedModes), which MELCloud would otherwise silently ignore.
+// With a nil dev it behaves like SetOperationMode.
+func (s *AtaDeviceState) SetOperationModeChecked(mode string, dev *Device) error {
+	if dev == nil {
+		return s.SetOperationMode(mode)
+	}
+	supported := dev.SupportedModes()
+	if _, ok := opModeStringToInt[mode]; ok && !slices.Contains(supported, mode) {
+		return fmt.Errorf("device does not support %s mode (supported: %s)", mode, strings.Join(supported, ", "))
+	}
+	return s.SetOperationMode(mode)
+}
+
 // SetTargetTemperature updates the SetTemperature and sets the corresponding EffectiveFlag.
 // Note: Temperature rounding should be handled by the caller based on the Device's
 // TemperatureIncrement field. For example:
 //
-//	if device.TemperatureIncrement > 0 {
-//	    // Round to nearest increment
-//	    temp = math.Round(temp/device.TemperatureIncrement) * device.TemperatureIncrement
-//	}
+//	temp = device.RoundTemperature(temp)
 //
+// In fan-only mode the value is stored but the flag is not set until the mode is changed
+// with SetOperationMode, so it is not sent. A zero temp is stored as well, but rejected by
+// SetDeviceState; SetTargetTemperatureChecked rejects it up front.
 func (s *AtaDeviceState) SetTargetTemperature(temp float64) {
 	s.SetTemperature = temp
-	s.EffectiveFlags |= FlagTargetTemp
+	s.setpointPending = true
+	if s.TemperatureControllable() {
+		s.EffectiveFlags |= FlagTargetTemp
+	}
+}
+
+// SetTargetTemperatureChecked is like SetTargetTemperature but checks temp against the
+// device's range for the current operation mode, and accepts 0 when that range includes it.
+// With a nil dev only the zero check applies.
+func (s *AtaDeviceState) SetTargetTemperatureChecked(temp float64, dev *Device) error {
+	if dev == nil {
+		if temp == 0 {
+			return ErrZeroSetTemperature
+		}
+		s.SetTargetTemperature(temp)
+		return nil
+	}
+	if temp == 0 && !dev.allowsZeroSetpoint(s.OperationMode) {
+		return ErrZeroSetTemperature
+	}
+	if min, max, ok := dev.TemperatureRange(s.OperationMode); ok && (temp < min || temp > max) {
+		return fmt.Errorf("target temperature %.1f outside range %.1f-%.1f for %s mode", temp, min, max, s.OperationModeString())
+	}
+	s.SetTargetTemperature(temp)
+	return nil
+}
+
+// ErrZeroSetTemperature is returned when a setpoint of 0 would be sent, which almost always
+// means SetTemperature was never filled in. SetTargetTemperatureChecked, Validate and
+// SetDeviceState only accept it for devices whose range for the mode, as reported by
+// ListDevices, goes down to 0.
+var ErrZeroSetTemperature = errors.New("target temperature is 0")
+
+// DefaultSetpoint returns the unit's default setpoint for an operation mode (OpMode* constant),
+// useful to pre-fill a UI when switching modes. It falls back to the current SetTemperature
+// when the unit reports no default for that mode.
+func (s *AtaDeviceState) DefaultSetpoint(mode int) float64 {
+	var def float64
+	switch mode {
+	case OpModeHeat:
+		def = s.DefaultHeatingSetTemperature
+	case OpModeCool, OpModeDry:
+		def = s.DefaultCoolingSetTemperature
+	}
+	if def == 0 {
+		return s.SetTemperature
+	}
+	return def
+}
+
+// SetDualSetpoints sets separate heating and cooling setpoints for heat_cool (auto) mode.
+// Both must lie within the device's automatic range, with heat not above cool.
+// Units without dual setpoints get a single SetTemperature halfway between the two.
+// With a nil dev the range check is skipped and both setpoints are written as given.
+func (s *AtaDeviceState) SetDualSetpoints(heat, cool float64, dev *Device) error {
+	if heat > cool {
+		return fmt.Errorf("heating setpoint %.1f is above cooling setpoint %.1f", heat, cool)
+	}
+	if dev == nil {
+		s.HeatSetTemperature = heat
+		s.CoolSetTemperature = cool
+		s.EffectiveFlags |= FlagHeatSetTemp | FlagCoolSetTemp
+		return nil
+	}
+	if min, max, ok := dev.TemperatureRange(OpModeHeatCool); ok && (heat < min || cool > max) {
+		return fmt.Errorf("setpoints %.1f-%.1f outside automatic range %.1f-%.1f", heat, cool, min, max)
+	}
+
+	if !dev.DualSetpoint {
+		return s.SetTargetTemperatureChecked(dev.RoundTemperature((heat+cool)/2), dev)
+	}
+	s.HeatSetTemperature = heat
+	s.CoolSetTemperature = cool
+	s.EffectiveFlags |= FlagHeatSetTemp | FlagCoolSetTemp
+	return nil
+}
+
+// SetChangeoverThreshold sets the room temperature at which heat_cool (auto) mode switches
+// between heating and cooling. temp is rounded to the device's increment and must lie
+// within its automatic range. Units without a configurable threshold ignore the setting,
+// which SetDeviceState reports as a *PartialApplyError. With a nil dev temp is rounded to
+// the default half-degree step and no range applies.
+func (s *AtaDeviceState) SetChangeoverThreshold(temp float64, dev *Device) error {
+	if dev == nil {
+		s.ChangeoverTemperature = math.Round(temp/defaultTemperatureIncrement) * defaultTemperatureIncrement
+		s.EffectiveFlags |= FlagChangeover
+		return nil
+	}
+	temp = dev.RoundTemperature(temp)
+	if min, max, ok := dev.TemperatureRange(OpModeHeatCool); ok && (temp < min || temp > max) {
+		return fmt.Errorf("changeover threshold %.1f outside automatic range %.1f-%.1f", temp, min, max)
+	}
+	s.ChangeoverTemperature = temp
+	s.EffectiveFlags |= FlagChangeover
+	return nil
+}
+
+// TemperatureControllable reports whether the setpoint applies in the current operation mode.
+// It is false in fan-only mode, where UIs should disable temperature controls.
+func (s *AtaDeviceState) TemperatureControllable() bool {
+	return s.OperationMode != OpModeFanOnly
+}
+
+// TemperatureDelta returns how far the room is from the setpoint: positive when the room
+// is warmer than SetTemperature, negative when it is colder.
+func (s *AtaDeviceState) TemperatureDelta() float64 {
+	return s.RoomTemperature - s.SetTemperature
+}
+
+// AtSetpoint reports whether the room temperature is within tolerance °C of the setpoint.
+func (s *AtaDeviceState) AtSetpoint(tolerance float64) bool {
+	return math.Abs(s.TemperatureDelta()) <= tolerance
+}
+
+// TempBounds describes the setpoint control for the current operation mode.
+// Min and Max are zero when the device reports no range for the mode.
+type TempBounds struct {
+	Min, Max, Step, Current float64
+}
+
+// ActiveTempBounds returns the setpoint range, step and current setpoint for the state's
+// operation mode, so generic UIs can render a temperature control without a mode switch.
+// With a nil dev only the default step and the current setpoint are filled in.
+func (s *AtaDeviceState) ActiveTempBounds(dev *Device) TempBounds {
+	if dev == nil {
+		return TempBounds{Step: defaultTemperatureIncrement, Current: s.SetTemperature}
+	}
+	min, max, _ := dev.TemperatureRange(s.OperationMode)
+	return TempBounds{Min: min, Max: max, Step: dev.TemperatureStep(), Current: s.SetTemperature}
+}
+
+// NudgeTemperature moves the setpoint one TemperatureIncrement up (dir > 0) or down (dir < 0),
+// clamped to the device's range for the current operation mode, and sets the flag.
+// It is intended for +/- buttons in user interfaces and does nothing in fan-only mode.
+// With a nil dev the default half-degree step is used and no range applies. A nudge that
+// would reach 0 outside a range including it is ignored, so it never sends a zero setpoint.
+func (s *AtaDeviceState) NudgeTemperature(dir int, dev *Device) {
+	if !s.TemperatureControllable() {
+		return
+	}
+	step := defaultTemperatureIncrement
+	if dev != nil {
+		step = dev.TemperatureStep()
+	}
+	temp := s.SetTemperature
+	switch {
+	case dir > 0:
+		temp += step
+	case dir < 0:
+		temp -= step
+	}
+	if dev != nil {
+		temp = dev.NormalizeTemperature(temp, s.OperationMode)
+	} else {
+		temp = math.Round(temp/step) * step
+	}
+	if temp == 0 && (dev == nil || !dev.allowsZeroSetpoint(s.OperationMode)) {
+		return
+	}
+	s.SetTargetTemperature(temp)
 }
 
 // SetFanSpeedMode updates the SetFanSpeed field from a string representation ("auto", "1", "2", etc.)
@@ -174,13 +578,8 @@ func (s *AtaDeviceState) SetFanSpeedMode(speed string) error {
 	// Try converting to integer
 	speedInt, err := strconv.Atoi(speed)
 	if err == nil && speedInt > 0 { // Assuming fan speeds are positive integers > 0
-		// Note: Fan speed validation should be handled by the caller if needed.
-		// For example, if you have access to the Device struct:
-		//
-		//   if device.NumberOfFanSpeeds > 0 && speedInt > device.NumberOfFanSpeeds {
-		//       return fmt.Errorf("fan speed %d exceeds maximum of %d", speedInt, device.NumberOfFanSpeeds)
-		//   }
-		//
+		// Note: Fan speed is not validated against the device here;
+		// use SetFanSpeedModeChecked when the Device is available.
 		s.SetFanSpeed = speedInt // Assign to the field
 		s.EffectiveFlags |= FlagFanSpeed
 		return nil
@@ -188,6 +587,26 @@ func (s *AtaDeviceState) SetFanSpeedMode(speed string) error {
 	return fmt.Errorf("invalid fan speed: %s", speed)
 }
 
+// SetFanSpeedModeChecked is like SetFanSpeedMode but also rejects speeds outside the
+// device's FanSpeedBounds, and "auto" on units without automatic fan speed.
+// With a nil dev it behaves like SetFanSpeedMode.
+func (s *AtaDeviceState) SetFanSpeedModeChecked(speed string, dev *Device) error {
+	if dev == nil {
+		return s.SetFanSpeedMode(speed)
+	}
+	min, max, auto := dev.FanSpeedBounds()
+	if speed == FanAuto {
+		if !auto {
+			return fmt.Errorf("device does not support automatic fan speed")
+		}
+		return s.SetFanSpeedMode(speed)
+	}
+	if speedInt, err := strconv.Atoi(speed); err == nil && max > 0 && (speedInt < min || speedInt > max) {
+		return fmt.Errorf("fan speed %d outside supported range %d-%d", speedInt, min, max)
+	}
+	return s.SetFanSpeedMode(speed)
+}
+
 // FanSpeedString returns the string representation ("auto", "1", "2", etc.) of the SetFanSpeed field.
 func (s *AtaDeviceState) FanSpeedString() string {
 	if s.SetFanSpeed == FanSpeedAuto { // Compare the field
@@ -278,8 +697,193 @@ func (s *AtaDeviceState) SetVaneHorizontal(pos string) error {
 	return fmt.Errorf("invalid horizontal vane position: %s", pos)
 }
 
+// SetVaneVerticalInt updates the VaneVertical field from a raw position (VaneVert* constant) and sets the flag.
+// Returns an error if the position is not a known vertical vane position.
+func (s *AtaDeviceState) SetVaneVerticalInt(pos int) error {
+	if _, ok := vaneVertIntToString[pos]; !ok {
+		return fmt.Errorf("invalid vertical vane position: %d", pos)
+	}
+	s.VaneVertical = pos
+	s.EffectiveFlags |= FlagVaneVertical
+	return nil
+}
+
+// SetVaneVerticalAngle points the vertical vane at deg degrees on units that report
+// fine-grained angles (Device.VaneVerticalAngles), and sets the flag. Units that only
+// support the coarse presets, and angles the unit does not list, return an error;
+// use SetVaneVertical for those. A nil dev is treated as a unit without angles.
+func (s *AtaDeviceState) SetVaneVerticalAngle(deg int, dev *Device) error {
+	if dev == nil || len(dev.VaneVerticalAngles) == 0 {
+		return fmt.Errorf("device does not support vane angles, only positions 1-5")
+	}
+	degrees := make([]string, len(dev.VaneVerticalAngles))
+	for i, a := range dev.VaneVerticalAngles {
+		if a.Degrees == deg {
+			s.VaneVertical = a.Position
+			s.EffectiveFlags |= FlagVaneVertical
+			return nil
+		}
+		degrees[i] = strconv.Itoa(a.Degrees)
+	}
+	return fmt.Errorf("unsupported vane angle %d° (supported: %s)", deg, strings.Join(degrees, ", "))
+}
+
+// VaneVerticalAngle returns the vertical vane angle in degrees, and false if the vane is
+// not at one of the device's fine-grained angles or dev is nil.
+func (s *AtaDeviceState) VaneVerticalAngle(dev *Device) (int, bool) {
+	if dev == nil {
+		return 0, false
+	}
+	for _, a := range dev.VaneVerticalAngles {
+		if a.Position == s.VaneVertical {
+			return a.Degrees, true
+		}
+	}
+	return 0, false
+}
+
+// SetVaneHorizontalInt updates the VaneHorizontal field from a raw position (VaneHoriz* constant) and sets the flag.
+// Returns an error if the position is not a known horizontal vane position.
+func (s *AtaDeviceState) SetVaneHorizontalInt(pos int) error {
+	if _, ok := vaneHorizIntToString[pos]; !ok {
+		return fmt.Errorf("invalid horizontal vane position: %d", pos)
+	}
+	s.VaneHorizontal = pos
+	s.EffectiveFlags |= FlagVaneHorizontal
+	return nil
+}
+
+// SetVanes updates both vane positions from their string representations and sets both flags.
+// Neither vane is changed if either position is invalid.
+func (s *AtaDeviceState) SetVanes(vertical, horizontal string) error {
+	v, ok := vaneVertStringToInt[vertical]
+	if !ok {
+		return fmt.Errorf("invalid vertical vane position: %s", vertical)
+	}
+	h, ok := vaneHorizStringToInt[horizontal]
+	if !ok {
+		return fmt.Errorf("invalid horizontal vane position: %s", horizontal)
+	}
+	s.VaneVertical = v
+	s.VaneHorizontal = h
+	s.EffectiveFlags |= FlagVaneVertical | FlagVaneHorizontal
+	return nil
+}
+
+// VaneState bundles both vane positions, using the strings of VaneVerticalString and
+// VaneHorizontalString. Swinging is true when either vane swings.
+type VaneState struct {
+	Vertical   string
+	Horizontal string
+	Swinging   bool
+}
+
+// VaneState returns the current vane positions.
+func (s *AtaDeviceState) VaneState() VaneState {
+	return VaneState{
+		Vertical:   s.VaneVerticalString(),
+		Horizontal: s.VaneHorizontalString(),
+		Swinging:   s.VaneVertical == VaneVertSwing || s.VaneHorizontal == VaneHorizSwing,
+	}
+}
+
+// SetVaneState applies both vane positions like SetVanes. If v.Swinging is true, both
+// vanes are set to swing regardless of the positions given.
+func (s *AtaDeviceState) SetVaneState(v VaneState) error {
+	if v.Swinging {
+		return s.SetVanes(VaneSwing, VaneSwing)
+	}
+	return s.SetVanes(v.Vertical, v.Horizontal)
+}
+
+// SetDemandPercentage limits the unit's capacity to pct percent (0-100) in DemandLimit and
+// sets the flag. Useful for load shedding during peak tariff windows; 100 removes the limit.
+func (s *AtaDeviceState) SetDemandPercentage(pct int) error {
+	if pct < 0 || pct > 100 {
+		return fmt.Errorf("invalid demand percentage: %d (must be 0-100)", pct)
+	}
+	s.DemandLimit = pct
+	s.EffectiveFlags |= FlagDemandPercent
+	return nil
+}
+
+// SetNightMode turns the outdoor unit's night (quiet) mode on or off and sets the flag.
+func (s *AtaDeviceState) SetNightMode(on bool) {
+	s.NightMode = on
+	s.EffectiveFlags |= FlagNightMode
+}
+
+// settingFields lists the settable fields together with the EffectiveFlag that sends them.
+var settingFields = []struct {
+	flag  int
+	name  string
+	value func(*AtaDeviceState) interface{}
+}{
+	{FlagPower, "Power", func(s *AtaDeviceState) interface{} { return s.Power }},
+	{FlagOperationMode, "OperationMode", func(s *AtaDeviceState) interface{} { return s.OperationMode }},
+	{FlagTargetTemp, "SetTemperature", func(s *AtaDeviceState) interface{} { return s.SetTemperature }},
+	{FlagFanSpeed, "SetFanSpeed", func(s *AtaDeviceState) interface{} { return s.SetFanSpeed }},
+	{FlagVaneVertical, "VaneVertical", func(s *AtaDeviceState) interface{} { return s.VaneVertical }},
+	{FlagVaneHorizontal, "VaneHorizontal", func(s *AtaDeviceState) interface{} { return s.VaneHorizontal }},
+	{FlagDemandPercent, "DemandLimit", func(s *AtaDeviceState) interface{} { return s.DemandLimit }},
+	{FlagHeatSetTemp, "HeatSetTemperature", func(s *AtaDeviceState) interface{} { return s.HeatSetTemperature }},
+	{FlagCoolSetTemp, "CoolSetTemperature", func(s *AtaDeviceState) interface{} { return s.CoolSetTemperature }},
+	{FlagChangeover, "AutoChangeoverTemperature", func(s *AtaDeviceState) interface{} { return s.ChangeoverTemperature }},
+	{FlagNightMode, "NightMode", func(s *AtaDeviceState) interface{} { return s.NightMode }},
+}
+
+// unappliedFields returns the names of the fields selected by flags whose value in
+// echo differs from the requested state.
+func unappliedFields(requested, echo *AtaDeviceState, flags int) []string {
+	var fields []string
+	for _, f := range settingFields {
+		if flags&f.flag != 0 && f.value(requested) != f.value(echo) {
+			fields = append(fields, f.name)
+		}
+	}
+	return fields
+}
+
+// unappliedFlags is like unappliedFields but returns the flags of the fields.
+func unappliedFlags(requested, echo *AtaDeviceState, flags int) int {
+	var unapplied int
+	for _, f := range settingFields {
+		if flags&f.flag != 0 && f.value(requested) != f.value(echo) {
+			unapplied |= f.flag
+		}
+	}
+	return unapplied
+}
+
+// changedFlags returns the EffectiveFlags needed to turn the settings of from into those of to.
+func changedFlags(from, to *AtaDeviceState) int {
+	var flags int
+	for _, f := range settingFields {
+		if f.value(from) != f.value(to) {
+			flags |= f.flag
+		}
+	}
+	return flags
+}
+
+// EqualSettings reports whether two states have the same user-controllable settings
+// (power, operation mode, target temperature, fan speed and vanes).
+// Volatile fields such as LastCommunication, EffectiveFlags and HasPendingCommand are ignored.
+func (s *AtaDeviceState) EqualSettings(other *AtaDeviceState) bool {
+	if s == nil || other == nil {
+		return s == other
+	}
+	return s.Power == other.Power &&
+		s.OperationMode == other.OperationMode &&
+		s.SetTemperature == other.SetTemperature &&
+		s.SetFanSpeed == other.SetFanSpeed &&
+		s.VaneVertical == other.VaneVertical &&
+		s.VaneHorizontal == other.VaneHorizontal
+}
+
 // ResetEffectiveFlags clears the flags used for setting state.
 // Useful after a successful SetDeviceState call or before setting new properties.
 func (s *AtaDeviceState) ResetEffectiveFlags() {
 	s.EffectiveFlags = 0
+	s.setpointPending = false
 }
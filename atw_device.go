@@ -0,0 +1,160 @@
+package melcloud
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// AtwDeviceState holds the state of an Air-to-Water (ATW) heat pump.
+// Only the fields needed for zone and hot water control are modeled so far.
+type AtwDeviceState struct {
+	DeviceID          int   `json:"DeviceID"`
+	BuildingID        int   `json:"BuildingID"` // Note: Not always in Get response
+	DeviceType        int   `json:"DeviceType"` // 1 for ATW
+	Power             bool  `json:"Power"`
+	EffectiveFlags    int64 `json:"EffectiveFlags"` // ATW flags exceed 32 bits
+	HasPendingCommand bool  `json:"HasPendingCommand"`
+
+	// Zones
+	HasZone2                bool    `json:"HasZone2"`
+	Zone1Power              bool    `json:"PowerZone1"` // Zone enabled; a disabled zone does not heat
+	Zone2Power              bool    `json:"PowerZone2"`
+	IdleZone1               bool    `json:"IdleZone1"` // Zone enabled but not currently demanding heat
+	IdleZone2               bool    `json:"IdleZone2"`
+	RoomTemperatureZone1    float64 `json:"RoomTemperatureZone1"`
+	RoomTemperatureZone2    float64 `json:"RoomTemperatureZone2"`
+	SetTemperatureZone1     float64 `json:"SetTemperatureZone1"`
+	SetTemperatureZone2     float64 `json:"SetTemperatureZone2"`
+	OperationModeZone1      int     `json:"OperationModeZone1"`
+	OperationModeZone2      int     `json:"OperationModeZone2"`
+	OutdoorTemperature      float64 `json:"OutdoorTemperature"`
+	HasHotWaterTank         bool    `json:"HasHotWaterTank"`
+	ForcedHotWaterMode      bool    `json:"ForcedHotWaterMode"`
+	TankWaterTemperature    float64 `json:"TankWaterTemperature"`
+	SetTankWaterTemperature float64 `json:"SetTankWaterTemperature"`
+}
+
+// EffectiveFlags for ATW devices. They are int64, since the zone flags do not fit in a
+// 32-bit int.
+const (
+	FlagAtwPower              int64 = 0x01
+	FlagAtwZone1OperationMode int64 = 0x08
+	FlagAtwZone2OperationMode int64 = 0x10
+	FlagAtwForcedHotWater     int64 = 0x10000
+	FlagAtwZone1Power         int64 = 0x40000000000
+	FlagAtwZone2Power         int64 = 0x80000000000
+)
+
+// SetPower updates the unit's main Power state and sets the corresponding EffectiveFlag.
+func (s *AtwDeviceState) SetPower(power bool) {
+	s.Power = power
+	s.EffectiveFlags |= FlagAtwPower
+}
+
+// SetZonePower enables or disables a heating zone (1 or 2) and sets the zone's flag,
+// so one zone can be turned off while the other keeps heating.
+// Disabling the last enabled zone is rejected while forced hot water mode is active;
+// otherwise it leaves the unit producing hot water only.
+func (s *AtwDeviceState) SetZonePower(zone int, on bool) error {
+	switch zone {
+	case 1:
+		if !on && (!s.HasZone2 || !s.Zone2Power) && s.ForcedHotWaterMode {
+			return fmt.Errorf("cannot disable zone 1: no other zone is enabled and forced hot water is active")
+		}
+		s.Zone1Power = on
+		s.EffectiveFlags |= FlagAtwZone1Power
+	case 2:
+		if !s.HasZone2 {
+			return fmt.Errorf("device has no zone 2")
+		}
+		if !on && !s.Zone1Power && s.ForcedHotWaterMode {
+			return fmt.Errorf("cannot disable zone 2: no other zone is enabled and forced hot water is active")
+		}
+		s.Zone2Power = on
+		s.EffectiveFlags |= FlagAtwZone2Power
+	default:
+		return fmt.Errorf("invalid zone: %d", zone)
+	}
+	return nil
+}
+
+// ResetEffectiveFlags clears the flags used for setting state.
+func (s *AtwDeviceState) ResetEffectiveFlags() {
+	s.EffectiveFlags = 0
+}
+
+// GetAtwDeviceState fetches the current state of an ATW device.
+func (c *Client) GetAtwDeviceState(deviceID, buildingID int) (*AtwDeviceState, error) {
+	return c.GetAtwDeviceStateContext(context.Background(), deviceID, buildingID)
+}
+
+// GetAtwDeviceStateContext is like GetAtwDeviceState but honors the context for cancellation.
+func (c *Client) GetAtwDeviceStateContext(ctx context.Context, deviceID, buildingID int) (*AtwDeviceState, error) {
+	url := c.url(fmt.Sprintf("/Device/Get?id=%d&buildingID=%d", deviceID, buildingID))
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create get device state request: %w", err)
+	}
+	c.setHeaders(req)
+
+	var state AtwDeviceState
+	if err := c.doJSON(req, "get device state", &state); err != nil {
+		return nil, fmt.Errorf("%s in building %d: %w", c.deviceLabel(deviceID), buildingID, err)
+	}
+	if state.DeviceType != DeviceTypeAtw {
+		return nil, fmt.Errorf("%s: %w: %d (not an ATW device)", c.deviceLabel(deviceID), ErrUnsupportedDeviceType, state.DeviceType)
+	}
+
+	// Add back BuildingID as it's not always present in the response
+	state.BuildingID = buildingID
+
+	return &state, nil
+}
+
+// SetAtwDeviceState sends updated state information to an ATW device.
+// Like SetDeviceState, the state must have EffectiveFlags set for the fields to change,
+// and the returned state has its flags cleared.
+func (c *Client) SetAtwDeviceState(state AtwDeviceState) (*AtwDeviceState, error) {
+	return c.SetAtwDeviceStateContext(context.Background(), state)
+}
+
+// SetAtwDeviceStateContext is like SetAtwDeviceState but honors the context for cancellation.
+func (c *Client) SetAtwDeviceStateContext(ctx context.Context, state AtwDeviceState) (*AtwDeviceState, error) {
+	if state.EffectiveFlags == 0 {
+		return nil, fmt.Errorf("SetAtwDeviceState requires EffectiveFlags to be set to indicate changes")
+	}
+	if state.DeviceType != DeviceTypeAtw {
+		return nil, fmt.Errorf("SetAtwDeviceState: %w: %d", ErrUnsupportedDeviceType, state.DeviceType)
+	}
+	if err := c.checkControl(state.DeviceID); err != nil {
+		return nil, err
+	}
+	state.HasPendingCommand = true // Must be true when sending commands
+
+	jsonBody, err := json.Marshal(state)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal set device state request body: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", c.url("/Device/SetAtw"), bytes.NewBuffer(jsonBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create set device state request: %w", err)
+	}
+	c.setHeaders(req)
+	req.Header.Set("Content-Type", "application/json")
+
+	var updatedState AtwDeviceState
+	if err := c.doJSON(req, "set device state", &updatedState); err != nil {
+		return nil, fmt.Errorf("%s%s: %w", c.deviceLabel(state.DeviceID), c.commandHint(state.DeviceID), err)
+	}
+	c.recordCommandSent(state.DeviceID)
+
+	updatedState.BuildingID = state.BuildingID
+	updatedState.ResetEffectiveFlags()
+	updatedState.HasPendingCommand = false
+
+	return &updatedState, nil
+}
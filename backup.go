@@ -0,0 +1,69 @@
+package melcloud
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ExportStates takes a Snapshot and encodes the current state of every ATA device as JSON,
+// for restoring later with RestoreStates. Devices whose state cannot be fetched are left out.
+func (c *Client) ExportStates(ctx context.Context) ([]byte, error) {
+	snapshots, err := c.Snapshot(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	states := make([]AtaDeviceState, 0, len(snapshots))
+	for _, snap := range snapshots {
+		if snap.State != nil {
+			states = append(states, *snap.State)
+		}
+	}
+	return json.MarshalIndent(states, "", "  ")
+}
+
+// RestoreStates applies settings exported by ExportStates. For each device it fetches the
+// current state and sends only the settings that differ, so restoring twice is harmless.
+// Devices that are no longer on the account are skipped. Failures for individual devices
+// are joined into the returned error; the remaining devices are still restored.
+func (c *Client) RestoreStates(ctx context.Context, data []byte) error {
+	var saved []AtaDeviceState
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return fmt.Errorf("failed to decode exported states: %w", err)
+	}
+
+	devices, err := c.ListDevicesContext(ctx)
+	if err != nil {
+		return err
+	}
+	known := newDeviceIndex(devices).ByID
+
+	errs := make([]error, len(saved))
+	c.forEach(len(saved), func(i int) {
+		want := saved[i]
+		d, ok := known[want.DeviceID]
+		if !ok {
+			return
+		}
+		current, err := c.GetDeviceStateContext(ctx, d.DeviceID, d.BuildingID)
+		if err != nil {
+			errs[i] = err
+			return
+		}
+
+		flags := changedFlags(current, &want)
+		if want.SetTemperature == 0 {
+			flags &^= FlagTargetTemp
+		}
+		if flags == 0 {
+			return
+		}
+		want.BuildingID = d.BuildingID
+		want.DeviceType = current.DeviceType
+		want.EffectiveFlags = flags
+		_, errs[i] = c.SetDeviceStateContext(ctx, want)
+	})
+	return errors.Join(errs...)
+}
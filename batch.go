@@ -0,0 +1,117 @@
+package melcloud
+
+import "context"
+
+// StateUpdate describes changes to apply to a device. Nil fields are left unchanged;
+// string fields take the same values as the corresponding AtaDeviceState setters.
+type StateUpdate struct {
+	Power          *bool
+	OperationMode  *string
+	Temperature    *float64
+	FanSpeed       *string
+	VaneVertical   *string
+	VaneHorizontal *string
+}
+
+// Apply sets the non-nil fields of u on s, setting the matching EffectiveFlags.
+// The operation mode is applied before the temperature, so a temperature is not
+// flagged when switching to fan-only mode.
+func (u StateUpdate) Apply(s *AtaDeviceState) error {
+	if u.Power != nil {
+		s.SetPower(*u.Power)
+	}
+	if u.OperationMode != nil {
+		if err := s.SetOperationMode(*u.OperationMode); err != nil {
+			return err
+		}
+	}
+	if u.Temperature != nil {
+		s.SetTargetTemperature(*u.Temperature)
+	}
+	if u.FanSpeed != nil {
+		if err := s.SetFanSpeedMode(*u.FanSpeed); err != nil {
+			return err
+		}
+	}
+	if u.VaneVertical != nil {
+		if err := s.SetVaneVertical(*u.VaneVertical); err != nil {
+			return err
+		}
+	}
+	if u.VaneHorizontal != nil {
+		if err := s.SetVaneHorizontal(*u.VaneHorizontal); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BatchUpdate is one entry for SetDeviceStateBatch.
+type BatchUpdate struct {
+	DeviceID   int
+	BuildingID int
+	Update     StateUpdate
+}
+
+// BatchResult is the outcome of one BatchUpdate. Err may be a *PartialApplyError,
+// in which case State is set as well.
+type BatchResult struct {
+	State *AtaDeviceState
+	Err   error
+}
+
+// SetDeviceStateBatch fetches, updates and sets the state of each device concurrently,
+// bounded by WithMaxConcurrency and paced by WithMinRequestInterval, and returns the
+// results keyed by DeviceID. A failing device does not stop the others. If a device
+// appears more than once, only its last result is kept.
+func (c *Client) SetDeviceStateBatch(ctx context.Context, updates []BatchUpdate) map[int]BatchResult {
+	results := make([]BatchResult, len(updates))
+	c.forEach(len(updates), func(i int) {
+		u := updates[i]
+		state, err := c.updateDeviceState(ctx, u.DeviceID, u.BuildingID, u.Update.Apply)
+		results[i] = BatchResult{State: state, Err: err}
+	})
+
+	byID := make(map[int]BatchResult, len(updates))
+	for i, u := range updates {
+		byID[u.DeviceID] = results[i]
+	}
+	return byID
+}
+
+// PowerOffAll takes a Snapshot and switches off every ATA unit that is on, concurrently
+// within the client's concurrency and rate limits. Units that are already off, devices of
+// other types and devices this account can only view are skipped. The returned map has an
+// entry for each device that was switched off (nil error) or could not be, including
+// devices whose state could not be fetched; only a ListDevices failure returns an error.
+func (c *Client) PowerOffAll(ctx context.Context) (map[int]error, error) {
+	snapshots, err := c.Snapshot(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make(map[int]error)
+	var targets []AtaDeviceState
+	for _, snap := range snapshots {
+		d := snap.Device
+		switch {
+		case d.DeviceType != DeviceTypeAta || !d.CanControl():
+		case snap.Err != nil:
+			results[d.DeviceID] = snap.Err
+		case snap.State.Power:
+			targets = append(targets, *snap.State)
+		}
+	}
+
+	errs := make([]error, len(targets))
+	c.forEach(len(targets), func(i int) {
+		state := targets[i]
+		state.ResetEffectiveFlags()
+		state.SetPower(false)
+		_, errs[i] = c.SetDeviceStateContext(ctx, state)
+	})
+	for i, state := range targets {
+		results[state.DeviceID] = errs[i]
+	}
+	return results, nil
+}
@@ -0,0 +1,51 @@
+package melcloud
+
+import (
+	"errors"
+	"net/http"
+	"time"
+)
+
+// ErrCircuitOpen is returned without contacting MELCloud while the circuit breaker is open
+// after repeated failures. See WithCircuitBreaker.
+var ErrCircuitOpen = errors.New("circuit breaker open: MELCloud appears unavailable")
+
+// circuitBreaker tracks consecutive request failures. Once threshold is reached, requests
+// fail fast until cooldown has passed; the next request then decides whether it closes
+// again (success) or reopens for another cooldown (failure).
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	openUntil time.Time
+}
+
+// allowRequest returns ErrCircuitOpen if the breaker is open.
+func (c *Client) allowRequest() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b := c.breaker
+	if b == nil || b.failures < b.threshold || !c.now().Before(b.openUntil) {
+		return nil
+	}
+	return ErrCircuitOpen
+}
+
+// recordResult updates the breaker after a request. Network errors, 429 and 5xx responses
+// count as failures; any other response means MELCloud is reachable.
+func (c *Client) recordResult(resp *http.Response, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b := c.breaker
+	if b == nil {
+		return
+	}
+	if err == nil && resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < 500 {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openUntil = c.now().Add(b.cooldown)
+	}
+}
@@ -0,0 +1,112 @@
+package melcloud
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Temperature display units, as returned by Building.TemperatureUnit.
+const (
+	TemperatureUnitCelsius    = "C"
+	TemperatureUnitFahrenheit = "F"
+)
+
+// TemperatureUnit returns the unit the building is configured to display temperatures in.
+// MELCloud always reports and accepts temperatures in °C regardless of this setting.
+func (b *Building) TemperatureUnit() string {
+	if b.UseFahrenheit {
+		return TemperatureUnitFahrenheit
+	}
+	return TemperatureUnitCelsius
+}
+
+// Prohibits reports whether any building-wide lock-out (power, mode or setpoint) is active.
+// These apply on top of the per-device prohibit flags.
+func (b *Building) Prohibits() bool {
+	return b.ProhibitPower || b.ProhibitOperationMode || b.ProhibitSetTemperature
+}
+
+// FrostProtection returns the building's frost protection range, and false if frost
+// protection is disabled.
+func (b *Building) FrostProtection() (min, max float64, ok bool) {
+	if !b.FrostProtectionEnabled {
+		return 0, 0, false
+	}
+	return b.FrostProtectionMin, b.FrostProtectionMax, true
+}
+
+// ListBuildings fetches the account's buildings with their settings and device structure.
+// Devices in the structure are not stamped with their floor and area; use ListDevices for
+// a flat device list. Unlike ListDevices, the result is never cached.
+func (c *Client) ListBuildings(ctx context.Context) ([]Building, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.url("/User/ListDevices"), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create list buildings request: %w", err)
+	}
+	c.setHeaders(req)
+
+	var buildings []Building
+	if err := c.doJSON(req, "list buildings", &buildings); err != nil {
+		return nil, err
+	}
+	return buildings, nil
+}
+
+// TemperatureUnit returns the display unit (TemperatureUnitCelsius or
+// TemperatureUnitFahrenheit) configured for a building.
+func (c *Client) TemperatureUnit(ctx context.Context, buildingID int) (string, error) {
+	buildings, err := c.ListBuildings(ctx)
+	if err != nil {
+		return "", err
+	}
+	for _, b := range buildings {
+		if b.ID == buildingID {
+			return b.TemperatureUnit(), nil
+		}
+	}
+	return "", fmt.Errorf("building %d not found", buildingID)
+}
+
+// SetTemperatureUnit changes the temperature display unit to unit (TemperatureUnitCelsius
+// or TemperatureUnitFahrenheit, case-insensitive). MELCloud stores it in the account's
+// application options, so it applies to every building and the MELCloud apps as well. It only
+// affects how temperatures are displayed: the API keeps reporting and accepting °C.
+//
+// The endpoints are undocumented; /User/GetApplicationOptions and
+// /User/UpdateApplicationOptions are the ones the MELCloud web app's settings page uses, with
+// the unit in the same UseFahrenheit field ListBuildings reports. The update replaces all
+// application options, so the current options are read first and sent back with only
+// UseFahrenheit changed. If the options read back have no UseFahrenheit field, nothing is
+// sent and an error is returned.
+func (c *Client) SetTemperatureUnit(unit string) error {
+	return c.SetTemperatureUnitContext(context.Background(), unit)
+}
+
+// SetTemperatureUnitContext is like SetTemperatureUnit but honors the context for cancellation.
+func (c *Client) SetTemperatureUnitContext(ctx context.Context, unit string) error {
+	var fahrenheit bool
+	switch strings.ToUpper(unit) {
+	case TemperatureUnitCelsius:
+	case TemperatureUnitFahrenheit:
+		fahrenheit = true
+	default:
+		return fmt.Errorf("invalid temperature unit %q (want %q or %q)", unit, TemperatureUnitCelsius, TemperatureUnitFahrenheit)
+	}
+
+	// Kept as raw JSON, so options this library does not model are sent back unchanged
+	var options map[string]json.RawMessage
+	if err := c.Do(ctx, "GET", "/User/GetApplicationOptions", nil, &options); err != nil {
+		return fmt.Errorf("failed to read application options: %w", err)
+	}
+	if _, ok := options["UseFahrenheit"]; !ok {
+		return fmt.Errorf("application options have no UseFahrenheit field")
+	}
+	options["UseFahrenheit"], _ = json.Marshal(fahrenheit)
+	if err := c.Do(ctx, "POST", "/User/UpdateApplicationOptions", options, nil); err != nil {
+		return fmt.Errorf("failed to update application options: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package melcloud
+
+import "slices"
+
+// cachedDeviceList returns a copy of the device list cached by WithDeviceListCache,
+// if it is younger than the TTL.
+func (c *Client) cachedDeviceList() ([]Device, bool) {
+	if c.listCacheTTL <= 0 {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.listCache == nil || c.now().Sub(c.listCachedAt) >= c.listCacheTTL {
+		return nil, false
+	}
+	return slices.Clone(c.listCache), true
+}
+
+// cacheDeviceList stores devices for WithDeviceListCache.
+func (c *Client) cacheDeviceList(devices []Device) {
+	if c.listCacheTTL <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.listCache = slices.Clone(devices)
+	c.listCachedAt = c.now()
+}
+
+// InvalidateCache discards the device list cached by WithDeviceListCache and the
+// validators used by WithConditionalRequests, so the next ListDevices call fetches a
+// fresh list from MELCloud.
+func (c *Client) InvalidateCache() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.listCache = nil
+	c.listValidators = listValidators{}
+}
@@ -0,0 +1,194 @@
+package melcloud
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// RecordMode selects how WithCassette uses its cassette file.
+type RecordMode int
+
+const (
+	// RecordModeReplay serves every request from the cassette and never contacts MELCloud.
+	RecordModeReplay RecordMode = iota
+	// RecordModeRecord sends requests to MELCloud and overwrites the cassette with them.
+	RecordModeRecord
+	// RecordModeAuto replays an existing cassette, and records one if the file is missing.
+	RecordModeAuto
+)
+
+// ErrCassetteMiss is returned in replay mode for a request the cassette has no recorded
+// (or no remaining) interaction for.
+var ErrCassetteMiss = errors.New("no recorded interaction for request")
+
+// redacted replaces credentials in recorded request bodies.
+const redacted = "REDACTED"
+
+// interaction is one recorded request and the response MELCloud gave to it.
+type interaction struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"` // Path and query, without the base URL's scheme and host
+	Body   string      `json:"body,omitempty"`
+	Status int         `json:"status"`
+	Header http.Header `json:"header,omitempty"`
+	Reply  string      `json:"reply"`
+}
+
+// cassette is an http.RoundTripper that records interactions to a file or replays them.
+// Replayed requests are matched by method and URL, in recording order, so a cassette of
+// repeated polls replays the same sequence of states.
+type cassette struct {
+	path string
+	mode RecordMode
+	next http.RoundTripper // Transport used when recording
+
+	mu           sync.Mutex
+	loaded       bool
+	loadErr      error
+	interactions []interaction
+	used         []bool
+}
+
+func (c *cassette) RoundTrip(req *http.Request) (*http.Response, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.loaded {
+		c.load()
+	}
+	if c.loadErr != nil {
+		return nil, c.loadErr
+	}
+	if c.mode == RecordModeRecord {
+		return c.record(req)
+	}
+	return c.replay(req)
+}
+
+// load reads the cassette file, switching RecordModeAuto to recording if it does not exist.
+func (c *cassette) load() {
+	c.loaded = true
+	if c.mode == RecordModeRecord {
+		return
+	}
+
+	data, err := os.ReadFile(c.path)
+	if c.mode == RecordModeAuto && errors.Is(err, os.ErrNotExist) {
+		c.mode = RecordModeRecord
+		return
+	}
+	if err != nil {
+		c.loadErr = fmt.Errorf("failed to read cassette: %w", err)
+		return
+	}
+	if err := json.Unmarshal(data, &c.interactions); err != nil {
+		c.loadErr = fmt.Errorf("failed to parse cassette %s: %w", c.path, err)
+		return
+	}
+	c.mode = RecordModeReplay
+	c.used = make([]bool, len(c.interactions))
+}
+
+// replay returns the response of the first unused interaction matching req.
+func (c *cassette) replay(req *http.Request) (*http.Response, error) {
+	url := req.URL.RequestURI()
+	for i, in := range c.interactions {
+		if c.used[i] || in.Method != req.Method || in.URL != url {
+			continue
+		}
+		c.used[i] = true
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", in.Status, http.StatusText(in.Status)),
+			StatusCode:    in.Status,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        in.Header.Clone(),
+			Body:          io.NopCloser(bytes.NewBufferString(in.Reply)),
+			ContentLength: int64(len(in.Reply)),
+			Request:       req,
+		}, nil
+	}
+	return nil, fmt.Errorf("%w: %s %s", ErrCassetteMiss, req.Method, url)
+}
+
+// record sends req with the next transport and appends the interaction to the cassette file.
+func (c *cassette) record(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = io.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	resp, err := c.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	// Store replies decompressed, since cassettes hold them as text
+	raw, err := decompress(resp)
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	reply, err := io.ReadAll(raw)
+	raw.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = int64(len(reply))
+	resp.Body = io.NopCloser(bytes.NewReader(reply))
+
+	c.interactions = append(c.interactions, interaction{
+		Method: req.Method,
+		URL:    req.URL.RequestURI(),
+		Body:   redactBody(body),
+		Status: resp.StatusCode,
+		Header: resp.Header.Clone(),
+		Reply:  string(reply),
+	})
+	if err := c.save(); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// save writes all recorded interactions, so the cassette is complete after every request.
+func (c *cassette) save() error {
+	data, err := json.MarshalIndent(c.interactions, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode cassette: %w", err)
+	}
+	if err := os.WriteFile(c.path, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write cassette: %w", err)
+	}
+	return nil
+}
+
+// redactBody hides the password of login requests. Other bodies are kept as sent.
+func redactBody(body []byte) string {
+	var fields map[string]interface{}
+	if json.Unmarshal(body, &fields) != nil {
+		return string(body)
+	}
+	if _, ok := fields["Password"]; !ok {
+		return string(body)
+	}
+	fields["Password"] = redacted
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return string(body)
+	}
+	return string(data)
+}
@@ -2,10 +2,18 @@ package melcloud
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"math/rand/v2"
 	"net/http"
+	neturl "net/url"
 	"os"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -23,6 +31,30 @@ type LoginResponse struct {
 	NextURLL     interface{} `json:"NextURLL"` // Assuming typo, might be NextURL
 }
 
+// isLoginError reports whether an ErrorId/ErrorCode value from the login response
+// signals a failure. MELCloud sends null or 0 on success, so only non-zero numbers and
+// non-empty strings count as errors.
+func isLoginError(v interface{}) bool {
+	switch v := v.(type) {
+	case nil:
+		return false
+	case float64:
+		return v != 0
+	case string:
+		return v != ""
+	case bool:
+		return v
+	default:
+		return true
+	}
+}
+
+// loginErrorInvalidCredentials is the login ErrorId MELCloud sends for a wrong email or password.
+const loginErrorInvalidCredentials = 1
+
+// ErrInvalidCredentials is returned by Login when MELCloud rejects the email or password.
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
 // LoginData contains the authentication context key.
 type LoginData struct {
 	ContextKey string `json:"ContextKey"`
@@ -38,25 +70,216 @@ type Structure struct {
 
 // Area contains Devices
 type Area struct {
+	ID      int      `json:"ID"`
 	Devices []Device `json:"Devices"`
 }
 
 // Floor contains Devices and Areas
 type Floor struct {
+	ID      int      `json:"ID"`
 	Devices []Device `json:"Devices"`
 	Areas   []Area   `json:"Areas"`
 }
 
 // Building represents a building containing devices.
 type Building struct {
+	ID        int       `json:"ID"`
+	Name      string    `json:"Name"`
+	TimeZone  int       `json:"TimeZone"` // MELCloud time zone ID
 	Structure Structure `json:"Structure"`
+
+	// Building-wide settings that apply to every device; see TemperatureUnit and Prohibits
+	UseFahrenheit          bool    `json:"UseFahrenheit"`
+	CoolingDisabled        bool    `json:"CoolingDisabled"`
+	ProhibitPower          bool    `json:"ProhibitPower"`
+	ProhibitOperationMode  bool    `json:"ProhibitOperationMode"`
+	ProhibitSetTemperature bool    `json:"ProhibitSetTemperature"`
+	FrostProtectionEnabled bool    `json:"FPEnabled"`
+	FrostProtectionMin     float64 `json:"FPMinTemperature"`
+	FrostProtectionMax     float64 `json:"FPMaxTemperature"`
+	HolidayModeEnabled     bool    `json:"HMEnabled"`
 	// Add other Building fields if needed
 }
 
+// PartialApplyError is returned by SetDeviceState when the state echoed back by MELCloud
+// does not reflect every requested change, e.g. when a unit accepts a power change but
+// rejects the requested mode.
+type PartialApplyError struct {
+	DeviceID int
+	Fields   []string        // Names of the requested fields that did not take effect
+	State    *AtaDeviceState // State as reported by MELCloud after the set
+	hint     string
+
+	// With WithRollbackOnPartialApply: whether the applied fields were reverted, or why not
+	RolledBack  bool
+	RollbackErr error
+}
+
+func (e *PartialApplyError) Error() string {
+	msg := fmt.Sprintf("device %d: requested changes not applied: %s%s", e.DeviceID, strings.Join(e.Fields, ", "), e.hint)
+	if e.Transitioning() {
+		msg += " (unit is in standby or changing mode, changes may still apply)"
+	}
+	switch {
+	case e.RolledBack:
+		msg += "; applied changes were rolled back"
+	case e.RollbackErr != nil:
+		msg += fmt.Sprintf("; rollback failed: %v", e.RollbackErr)
+	}
+	return msg
+}
+
+// Transitioning reports whether the unit was in standby or changing mode when it echoed
+// the state, in which case the fields may still change once the transition completes
+// (e.g. observe it with WaitFor) rather than having been rejected.
+func (e *PartialApplyError) Transitioning() bool {
+	return e.State != nil && e.State.Transitioning()
+}
+
+// ErrResponseTooLarge is returned when a response body exceeds the configured maximum size.
+var ErrResponseTooLarge = errors.New("response body exceeds maximum size")
+
 // Client holds the API client state, including the auth token.
 type Client struct {
-	token      string
-	httpClient *http.Client
+	token           string
+	sessionExpiry   time.Time // Zero when MELCloud reported no session lifetime
+	httpClient      *http.Client
+	baseURL         string
+	maxResponseSize int64
+
+	minRequestInterval time.Duration
+	maxConcurrency     int
+	autoRound          bool
+	preserveUnknown    bool
+	pollJitter         time.Duration
+	breaker            *circuitBreaker
+	now                func() time.Time // Clock used by the circuit breaker, duplicate suppression, RateBudget and Controllable
+
+	transport       http.RoundTripper // Custom transport from WithTransport, if any
+	cassette        *cassette         // Record/replay wrapper around transport, see WithCassette
+	maxIdleConns    int               // 0: match maxConcurrency
+	idleConnTimeout time.Duration
+
+	mu          sync.Mutex
+	devices     map[int]Device // Last known devices by DeviceID, refreshed by ListDevices
+	nextRequest time.Time      // Earliest time the next request may be sent
+
+	dedupWindow  time.Duration
+	lastCommands map[int]sentCommand // Last SetAta payload per DeviceID, see WithDuplicateSuppression
+	commandsSent map[int]time.Time   // When a command was last accepted per DeviceID, see StuckCommands
+
+	conditional    bool
+	listValidators listValidators // ETag/Last-Modified of the last ListDevices response
+
+	persistSession bool
+	rollback       bool // Revert partially applied sets, see WithRollbackOnPartialApply
+	strictJSON     bool // Reject responses with unmodeled fields, see WithStrictJSON
+
+	recentRequests []time.Time // Send times within the last rateWindow, see RateBudget
+	throttledUntil time.Time   // End of the backoff after a 429 response
+
+	listCacheTTL time.Duration
+	listCache    []Device // Last ListDevices result, see WithDeviceListCache
+	listCachedAt time.Time
+}
+
+// newClient creates an unauthenticated Client with defaults and the given options applied.
+func newClient(opts ...Option) *Client {
+	c := &Client{
+		httpClient:      &http.Client{Timeout: 10 * time.Second},
+		baseURL:         baseURL,
+		maxResponseSize: defaultMaxResponseSize,
+		maxConcurrency:  defaultMaxConcurrency,
+		idleConnTimeout: defaultIdleConnTimeout,
+		now:             time.Now,
+		persistSession:  true,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	if c.transport == nil {
+		// All requests go to a single host, so allow every idle connection to be kept for it
+		idle := c.maxIdleConns
+		if idle == 0 {
+			idle = c.maxConcurrency // Helpers like forEach would otherwise churn connections
+		}
+		t := http.DefaultTransport.(*http.Transport).Clone()
+		t.MaxIdleConns = idle
+		t.MaxIdleConnsPerHost = idle
+		t.IdleConnTimeout = c.idleConnTimeout
+		c.transport = t
+	}
+	c.httpClient.Transport = c.transport
+	if c.cassette != nil {
+		c.cassette.next = c.transport
+		c.httpClient.Transport = c.cassette
+	}
+	return c
+}
+
+// rememberDevices replaces the cached device metadata with the given list.
+func (c *Client) rememberDevices(devices []Device) {
+	known := make(map[int]Device, len(devices))
+	for _, d := range devices {
+		known[d.DeviceID] = d
+	}
+	c.mu.Lock()
+	c.devices = known
+	c.mu.Unlock()
+}
+
+// knownDevice returns the cached metadata for deviceID from the last ListDevices call.
+func (c *Client) knownDevice(deviceID int) (Device, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	d, ok := c.devices[deviceID]
+	return d, ok
+}
+
+// checkControl returns ErrReadOnlyAccess if the last ListDevices result shows that the
+// account cannot control deviceID.
+func (c *Client) checkControl(deviceID int) error {
+	if d, ok := c.knownDevice(deviceID); ok && !d.CanControl() {
+		return fmt.Errorf("%s: %w", c.deviceLabel(deviceID), ErrReadOnlyAccess)
+	}
+	return nil
+}
+
+// deviceLabel describes a device for error messages, e.g. "device 12345 (Living Room)".
+// The name is only included when known from a previous ListDevices call.
+func (c *Client) deviceLabel(deviceID int) string {
+	if d, ok := c.knownDevice(deviceID); ok && d.DeviceName != "" {
+		return fmt.Sprintf("device %d (%s)", deviceID, d.DeviceName)
+	}
+	return fmt.Sprintf("device %d", deviceID)
+}
+
+// commandHint returns extra context for errors about commands not applying,
+// or "" when there is nothing to add.
+func (c *Client) commandHint(deviceID int) string {
+	if d, ok := c.knownDevice(deviceID); ok && d.HasWiredController() {
+		return " (device has a wired remote controller that may override MELCloud commands)"
+	}
+	return ""
+}
+
+// url builds the full request URL for an API path such as "/User/ListDevices".
+func (c *Client) url(path string) string {
+	return c.baseURL + "/" + strings.TrimLeft(path, "/")
+}
+
+// normalizeBaseURL trims trailing slashes and lower-cases the scheme and host of a base
+// URL, so that joining it with request paths never yields "//" or mixed-case hosts.
+func normalizeBaseURL(raw string) string {
+	raw = strings.TrimRight(strings.TrimSpace(raw), "/")
+	u, err := neturl.Parse(raw)
+	if err != nil || u.Host == "" {
+		return raw
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	return u.String()
 }
 
 // setHeaders adds the necessary headers for authenticated requests.
@@ -67,22 +290,206 @@ func (c *Client) setHeaders(req *http.Request) {
 	// Add other headers from _headers in python if needed
 }
 
+// throttle blocks until the client's minimum request interval allows another request.
+func (c *Client) throttle(ctx context.Context) error {
+	if c.minRequestInterval <= 0 {
+		return nil
+	}
+
+	c.mu.Lock()
+	now := time.Now()
+	at := c.nextRequest
+	if at.Before(now) {
+		at = now
+	}
+	c.nextRequest = at.Add(c.minRequestInterval)
+	c.mu.Unlock()
+
+	return sleepContext(ctx, time.Until(at))
+}
+
+// sleepContext waits for d, returning early with the context's error if it is cancelled.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// jitter returns a random delay in [0, pollJitter) to spread out polling, or 0 if disabled.
+func (c *Client) jitter() time.Duration {
+	if c.pollJitter <= 0 {
+		return 0
+	}
+	return rand.N(c.pollJitter)
+}
+
+// limitedBody wraps a response body and fails with ErrResponseTooLarge
+// once more than max bytes have been read.
+type limitedBody struct {
+	r    io.Reader
+	read int64
+	max  int64
+}
+
+func (l *limitedBody) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.read += int64(n)
+	if l.read > l.max {
+		return n, ErrResponseTooLarge
+	}
+	return n, err
+}
+
+// limitBody applies the client's response size limit to body.
+func (c *Client) limitBody(body io.Reader) io.Reader {
+	if c.maxResponseSize <= 0 {
+		return body
+	}
+	return &limitedBody{r: io.LimitReader(body, c.maxResponseSize+1), max: c.maxResponseSize}
+}
+
+// decompress returns the body of resp, gunzipped if it is gzip-encoded. Closing the
+// returned reader also closes resp.Body.
+func decompress(resp *http.Response) (io.ReadCloser, error) {
+	if !strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+		return resp.Body, nil
+	}
+	zr, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return &gzipBody{Reader: zr, body: resp.Body}, nil
+}
+
+// gzipBody is a gunzipped response body that closes both the gzip reader and the
+// underlying body.
+type gzipBody struct {
+	*gzip.Reader
+	body io.Closer
+}
+
+func (g *gzipBody) Close() error {
+	err := g.Reader.Close()
+	if cerr := g.body.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// doJSON executes req and decodes the JSON response body into out, if out is non-nil.
+// op names the call in error messages (e.g. "list devices").
+func (c *Client) doJSON(req *http.Request, op string, out interface{}) error {
+	_, err := c.do(req, op, out)
+	return err
+}
+
+// do is like doJSON but also returns the response headers.
+func (c *Client) do(req *http.Request, op string, out interface{}) (http.Header, error) {
+	return c.doWith(c.httpClient, req, op, out)
+}
+
+// doWith is like do but sends the request with the given HTTP client.
+func (c *Client) doWith(hc *http.Client, req *http.Request, op string, out interface{}) (http.Header, error) {
+	if err := c.allowRequest(); err != nil {
+		return nil, fmt.Errorf("%s request not sent: %w", op, err)
+	}
+	if err := c.throttle(req.Context()); err != nil {
+		return nil, fmt.Errorf("%s request not sent: %w", op, err)
+	}
+
+	// Ask for gzip explicitly and decompress below, so responses are handled the same with
+	// any transport (Go's transport only decompresses transparently when it asked itself)
+	if req.Header.Get("Accept-Encoding") == "" {
+		req.Header.Set("Accept-Encoding", "gzip")
+	}
+
+	resp, err := hc.Do(req)
+	c.recordRequest(resp)
+	if req.Context().Err() == nil {
+		// Cancellation by the caller says nothing about MELCloud's health
+		c.recordResult(resp, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute %s request: %w", op, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		return resp.Header, fmt.Errorf("%s: %w", op, errNotModified)
+	}
+
+	raw, err := decompress(resp)
+	if err != nil {
+		return resp.Header, fmt.Errorf("failed to decompress %s response: %w", op, err)
+	}
+	defer raw.Close()
+	body := c.limitBody(raw) // Limits the decompressed size
+	if resp.StatusCode != http.StatusOK {
+		var errBody map[string]interface{}
+		if err := json.NewDecoder(body).Decode(&errBody); err == nil {
+			return resp.Header, fmt.Errorf("%s failed with status code: %d, details: %v", op, resp.StatusCode, errBody)
+		}
+		return resp.Header, fmt.Errorf("%s failed with status code: %d", op, resp.StatusCode)
+	}
+
+	if out == nil {
+		return resp.Header, nil
+	}
+	if c.strictJSON {
+		data, err := io.ReadAll(body)
+		if err == nil {
+			err = json.Unmarshal(data, out)
+		}
+		if err == nil {
+			err = checkUnknownFields(data, out)
+		}
+		if err != nil {
+			return resp.Header, fmt.Errorf("failed to decode %s response: %w", op, err)
+		}
+		return resp.Header, nil
+	}
+	if err := json.NewDecoder(body).Decode(out); err != nil {
+		return resp.Header, fmt.Errorf("failed to decode %s response: %w", op, err)
+	}
+	return resp.Header, nil
+}
+
 // Login authenticates with MELCloud using email and password from environment variables
-// and returns a new Client.
-func Login() (*Client, error) {
+// and returns a new Client configured with the given options.
+func Login(opts ...Option) (*Client, error) {
+	return LoginContext(context.Background(), opts...)
+}
+
+// LoginContext is like Login but honors the context for cancellation. If ctx has a
+// deadline, it replaces the client's request timeout for the login request only,
+// so a slow login can be given more time than later device calls.
+func LoginContext(ctx context.Context, opts ...Option) (*Client, error) {
 	email := os.Getenv("MELCLOUD_EMAIL")
 	password := os.Getenv("MELCLOUD_PASSWORD")
 
 	if email == "" || password == "" {
 		return nil, fmt.Errorf("MELCLOUD_EMAIL and MELCLOUD_PASSWORD environment variables must be set")
 	}
+	return login(ctx, email, password, opts...)
+}
 
+// login performs the ClientLogin request and returns an authenticated client.
+func login(ctx context.Context, email, password string, opts ...Option) (*Client, error) {
+	client := newClient(opts...)
 	body := map[string]interface{}{
 		"Email":           email,
 		"Password":        password,
 		"Language":        0, // Assuming default language
 		"AppVersion":      appVersion,
-		"Persist":         true,
+		"Persist":         client.persistSession,
 		"CaptchaResponse": nil,
 	}
 
@@ -91,34 +498,29 @@ func Login() (*Client, error) {
 		return nil, fmt.Errorf("failed to marshal login request body: %w", err)
 	}
 
-	httpClient := &http.Client{Timeout: 10 * time.Second}
-	req, err := http.NewRequest("POST", fmt.Sprintf("%s/Login/ClientLogin", baseURL), bytes.NewBuffer(jsonBody))
+	req, err := http.NewRequestWithContext(ctx, "POST", client.url("/Login/ClientLogin"), bytes.NewBuffer(jsonBody))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create login request: %w", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("User-Agent", "melcloud-go") // Simple user agent
 
-	resp, err := httpClient.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to execute login request: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		var errBody map[string]interface{}
-		if err := json.NewDecoder(resp.Body).Decode(&errBody); err == nil {
-			return nil, fmt.Errorf("login failed with status code: %d, details: %v", resp.StatusCode, errBody)
-		}
-		return nil, fmt.Errorf("login failed with status code: %d", resp.StatusCode)
+	hc := client.httpClient
+	if _, ok := ctx.Deadline(); ok {
+		loginClient := *hc
+		loginClient.Timeout = 0
+		hc = &loginClient
 	}
 
 	var loginResponse LoginResponse
-	if err := json.NewDecoder(resp.Body).Decode(&loginResponse); err != nil {
-		return nil, fmt.Errorf("failed to decode login response: %w", err)
+	if _, err := client.doWith(hc, req, "login", &loginResponse); err != nil {
+		return nil, err
 	}
 
-	if loginResponse.ErrorId != nil || loginResponse.ErrorCode != nil {
+	if id, ok := loginResponse.ErrorId.(float64); ok && id == loginErrorInvalidCredentials {
+		return nil, fmt.Errorf("login API returned an error: ID=%v, Code=%v: %w", loginResponse.ErrorId, loginResponse.ErrorCode, ErrInvalidCredentials)
+	}
+	if isLoginError(loginResponse.ErrorId) || isLoginError(loginResponse.ErrorCode) {
 		return nil, fmt.Errorf("login API returned an error: ID=%v, Code=%v", loginResponse.ErrorId, loginResponse.ErrorCode)
 	}
 
@@ -126,113 +528,169 @@ func Login() (*Client, error) {
 		return nil, fmt.Errorf("login response did not contain ContextKey")
 	}
 
-	client := &Client{
-		token:      loginResponse.LoginData.ContextKey,
-		httpClient: httpClient,
+	client.token = loginResponse.LoginData.ContextKey
+	if loginResponse.LoginMinutes > 0 {
+		client.sessionExpiry = client.now().Add(time.Duration(loginResponse.LoginMinutes) * time.Minute)
 	}
 
 	return client, nil
 }
 
-// ListDevices fetches all devices associated with the account.
-func (c *Client) ListDevices() ([]Device, error) {
-	req, err := http.NewRequest("GET", fmt.Sprintf("%s/User/ListDevices", baseURL), nil)
+// SessionExpiry returns when the login session expires, as reported by MELCloud's
+// LoginMinutes. ok is false when MELCloud reported no lifetime, which is usual for
+// persistent sessions (see WithPersistSession).
+func (c *Client) SessionExpiry() (expiry time.Time, ok bool) {
+	return c.sessionExpiry, !c.sessionExpiry.IsZero()
+}
+
+// ValidateCredentials checks whether email and password are accepted by MELCloud without
+// keeping a session: it logs in and immediately logs out again. Rejected credentials return
+// (false, nil); network, server and other failures return (false, err).
+func ValidateCredentials(email, password string) (bool, error) {
+	return validateCredentials(email, password)
+}
+
+func validateCredentials(email, password string, opts ...Option) (bool, error) {
+	if email == "" || password == "" {
+		return false, nil
+	}
+	client, err := login(context.Background(), email, password, opts...)
+	if errors.Is(err, ErrInvalidCredentials) {
+		return false, nil
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to create list devices request: %w", err)
+		return false, err
 	}
-	c.setHeaders(req)
+	// The credentials were accepted; a failed logout only leaves the session to expire.
+	_ = client.Logout(context.Background())
+	return true, nil
+}
 
-	resp, err := c.httpClient.Do(req)
+// Logout ends the client's session. The client cannot be used for further requests afterwards.
+func (c *Client) Logout(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.url("/Login/Logout"), nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to execute list devices request: %w", err)
+		return fmt.Errorf("failed to create logout request: %w", err)
 	}
-	defer resp.Body.Close()
+	c.setHeaders(req)
 
-	if resp.StatusCode != http.StatusOK {
-		var errBody map[string]interface{}
-		if err := json.NewDecoder(resp.Body).Decode(&errBody); err == nil {
-			return nil, fmt.Errorf("list devices failed with status code: %d, details: %v", resp.StatusCode, errBody)
-		}
-		return nil, fmt.Errorf("list devices failed with status code: %d", resp.StatusCode)
-	}
+	_, err = c.do(req, "logout", nil)
+	c.token = ""
+	return err
+}
 
-	var buildings []Building
-	if err := json.NewDecoder(resp.Body).Decode(&buildings); err != nil {
-		return nil, fmt.Errorf("failed to decode list devices response: %w", err)
+// ListDevices fetches all devices associated with the account.
+func (c *Client) ListDevices() ([]Device, error) {
+	return c.ListDevicesContext(context.Background())
+}
+
+// ListDevicesContext is like ListDevices but honors the context for cancellation.
+func (c *Client) ListDevicesContext(ctx context.Context) ([]Device, error) {
+	if devices, ok := c.cachedDeviceList(); ok {
+		return devices, nil
+	}
+	if devices, ok := c.unvalidatedDeviceList(); ok {
+		return devices, nil
 	}
 
-	// Extract devices from the nested structure, similar to pymelcloud
-	var allDevices []Device
-	visited := make(map[int]struct{}) // Use map for efficient lookup
+	req, err := c.newListDevicesRequest(ctx)
+	if err != nil {
+		return nil, err
+	}
+	cached := c.setConditionalHeaders(req)
 
-	for _, building := range buildings {
-		structure := building.Structure
-		for _, device := range structure.Devices {
-			if _, found := visited[device.DeviceID]; !found {
-				allDevices = append(allDevices, device)
-				visited[device.DeviceID] = struct{}{}
-			}
+	var buildings []Building
+	header, err := c.do(req, "list devices", &buildings)
+	if errors.Is(err, errNotModified) {
+		if cached != nil {
+			c.cacheDeviceList(cached)
+			return cached, nil
 		}
-		for _, area := range structure.Areas {
-			for _, device := range area.Devices {
-				if _, found := visited[device.DeviceID]; !found {
-					allDevices = append(allDevices, device)
-					visited[device.DeviceID] = struct{}{}
-				}
-			}
+		// A 304 to a request without validators (e.g. from a caching proxy) leaves nothing
+		// to reuse, so ask once more for a full response
+		if req, err = c.newListDevicesRequest(ctx); err != nil {
+			return nil, err
 		}
-		for _, floor := range structure.Floors {
-			for _, device := range floor.Devices {
-				if _, found := visited[device.DeviceID]; !found {
-					allDevices = append(allDevices, device)
-					visited[device.DeviceID] = struct{}{}
-				}
-			}
-			for _, area := range floor.Areas {
-				for _, device := range area.Devices {
-					if _, found := visited[device.DeviceID]; !found {
-						allDevices = append(allDevices, device)
-						visited[device.DeviceID] = struct{}{}
-					}
-				}
-			}
+		req.Header.Set("Cache-Control", "no-cache")
+		header, err = c.do(req, "list devices", &buildings)
+		if errors.Is(err, errNotModified) {
+			return nil, errors.New("list devices: server answered 304 Not Modified without a previous device list to reuse")
 		}
 	}
+	if err != nil {
+		return nil, err
+	}
+
+	allDevices := flattenBuildings(buildings)
+
+	c.rememberDevices(allDevices)
+	c.rememberListValidators(header, allDevices)
+	c.cacheDeviceList(allDevices)
 
 	return allDevices, nil
 }
 
-// GetDeviceState fetches the current state of a specific device.
-// Note: MELCloud rate limits this endpoint. Avoid calling too frequently.
-func (c *Client) GetDeviceState(deviceID, buildingID int) (*AtaDeviceState, error) {
-	url := fmt.Sprintf("%s/Device/Get?id=%d&buildingID=%d", baseURL, deviceID, buildingID)
-	req, err := http.NewRequest("GET", url, nil)
+// newListDevicesRequest creates an authenticated ListDevices request.
+func (c *Client) newListDevicesRequest(ctx context.Context) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.url("/User/ListDevices"), nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create get device state request: %w", err)
+		return nil, fmt.Errorf("failed to create list devices request: %w", err)
 	}
 	c.setHeaders(req)
+	return req, nil
+}
 
-	resp, err := c.httpClient.Do(req)
+// ListDevicesInArea returns the devices located in the given area.
+func (c *Client) ListDevicesInArea(areaID int) ([]Device, error) {
+	devices, err := c.ListDevices()
 	if err != nil {
-		return nil, fmt.Errorf("failed to execute get device state request: %w", err)
+		return nil, err
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		var errBody map[string]interface{}
-		if err := json.NewDecoder(resp.Body).Decode(&errBody); err == nil {
-			return nil, fmt.Errorf("get device state failed for device %d (building %d) with status code: %d, details: %v", deviceID, buildingID, resp.StatusCode, errBody)
+	var inArea []Device
+	for _, d := range devices {
+		if d.AreaID == areaID {
+			inArea = append(inArea, d)
 		}
-		return nil, fmt.Errorf("get device state failed for device %d (building %d) with status code: %d", deviceID, buildingID, resp.StatusCode)
+	}
+	return inArea, nil
+}
+
+// GetDeviceState fetches the current state of a specific device.
+// Only ATA devices are supported; other device types return ErrUnsupportedDeviceType
+// (use GetAtwDeviceState for ATW devices).
+// Powered-off devices keep reporting their last SetTemperature, which is returned as is.
+// Note: MELCloud rate limits this endpoint. Avoid calling too frequently.
+func (c *Client) GetDeviceState(deviceID, buildingID int) (*AtaDeviceState, error) {
+	return c.GetDeviceStateContext(context.Background(), deviceID, buildingID)
+}
+
+// GetDeviceStateContext is like GetDeviceState but honors the context for cancellation.
+func (c *Client) GetDeviceStateContext(ctx context.Context, deviceID, buildingID int) (*AtaDeviceState, error) {
+	// Fail early if ListDevices already told us this is not an ATA unit
+	if d, ok := c.knownDevice(deviceID); ok && d.DeviceType != DeviceTypeAta {
+		return nil, fmt.Errorf("%s: %w: %d", c.deviceLabel(deviceID), ErrUnsupportedDeviceType, d.DeviceType)
 	}
 
+	url := c.url(fmt.Sprintf("/Device/Get?id=%d&buildingID=%d", deviceID, buildingID))
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create get device state request: %w", err)
+	}
+	c.setHeaders(req)
+
 	var state AtaDeviceState
-	if err := json.NewDecoder(resp.Body).Decode(&state); err != nil {
-		return nil, fmt.Errorf("failed to decode get device state response for device %d: %w", deviceID, err)
+	if err := c.doJSON(req, "get device state", c.stateTarget(&state)); err != nil {
+		return nil, fmt.Errorf("%s in building %d: %w", c.deviceLabel(deviceID), buildingID, err)
+	}
+	if state.DeviceType != DeviceTypeAta {
+		return nil, fmt.Errorf("%s: %w: %d", c.deviceLabel(deviceID), ErrUnsupportedDeviceType, state.DeviceType)
 	}
 
 	// Add back BuildingID as it's not always present in the response
 	state.BuildingID = buildingID
+	if d, ok := c.knownDevice(deviceID); ok && state.DeviceName == "" {
+		state.DeviceName = d.DeviceName
+	}
 
 	return &state, nil
 }
@@ -240,59 +698,348 @@ func (c *Client) GetDeviceState(deviceID, buildingID int) (*AtaDeviceState, erro
 // SetDeviceState sends updated state information to a device.
 // The input `state` should be a modified version of a previously fetched state.
 // It *must* have the correct `EffectiveFlags` and `HasPendingCommand` set.
+//
+// The returned state has EffectiveFlags and HasPendingCommand cleared, so it can be
+// modified and sent again without resending stale flags. The input state is not modified.
+//
+// If MELCloud's response shows that some of the flagged fields did not change, the
+// returned error is a *PartialApplyError and the returned state is still set. With
+// WithRollbackOnPartialApply, the applied fields are reverted first and the returned state
+// is the one reported after the rollback.
+//
+// A zero BuildingID is filled in from the last ListDevices result when the device is known,
+// and devices the account can only view fail early with ErrReadOnlyAccess.
 func (c *Client) SetDeviceState(state AtaDeviceState) (*AtaDeviceState, error) {
+	return c.SetDeviceStateContext(context.Background(), state)
+}
+
+// SetDeviceStateWithFlags sends state with EffectiveFlags replaced by flags, bypassing the
+// per-field setters. It is meant for replaying captured commands or trying undocumented
+// flags; the caller is responsible for the flags matching the fields to change.
+func (c *Client) SetDeviceStateWithFlags(state AtaDeviceState, flags int) (*AtaDeviceState, error) {
+	return c.SetDeviceStateWithFlagsContext(context.Background(), state, flags)
+}
+
+// SetDeviceStateWithFlagsContext is like SetDeviceStateWithFlags but honors the context for
+// cancellation.
+func (c *Client) SetDeviceStateWithFlagsContext(ctx context.Context, state AtaDeviceState, flags int) (*AtaDeviceState, error) {
+	state.EffectiveFlags = flags
+	return c.SetDeviceStateContext(ctx, state)
+}
+
+// SetDeviceStateRaw POSTs payload to SetAta exactly as given and returns the decoded
+// response. It is an escape hatch for replaying captured app traffic and probing
+// undocumented fields: none of SetDeviceState's checks, defaults or flag handling apply,
+// and the returned state keeps the EffectiveFlags MELCloud echoed.
+func (c *Client) SetDeviceStateRaw(ctx context.Context, payload json.RawMessage) (*AtaDeviceState, error) {
+	var target struct{ DeviceID int }
+	if err := json.Unmarshal(payload, &target); err != nil {
+		return nil, fmt.Errorf("invalid SetAta payload: %w", err)
+	}
+	return c.sendState(ctx, c.url("/Device/SetAta"), target.DeviceID, payload)
+}
+
+// SetDeviceStateContext is like SetDeviceState but honors the context for cancellation.
+func (c *Client) SetDeviceStateContext(ctx context.Context, state AtaDeviceState) (*AtaDeviceState, error) {
 	// Ensure crucial fields for setting state are present/set
 	if state.EffectiveFlags == 0 {
 		return nil, fmt.Errorf("SetDeviceState requires EffectiveFlags to be set to indicate changes")
 	}
+	// Look the device up once, so all checks see the same ListDevices result
+	d, known := c.knownDevice(state.DeviceID)
+	if known && !d.CanControl() {
+		return nil, fmt.Errorf("%s: %w", c.deviceLabel(state.DeviceID), ErrReadOnlyAccess)
+	}
 	state.HasPendingCommand = true // Must be true when sending commands
 
+	// Fill in a BuildingID the caller did not carry over from the fetched state
+	if known && state.BuildingID == 0 {
+		state.BuildingID = d.BuildingID
+	}
+
+	// Refuse to send a payload for the wrong kind of unit; MELCloud silently drops those
+	if known && d.DeviceType != state.DeviceType {
+		return nil, fmt.Errorf("%s: %w: state has type %d, device is type %d", c.deviceLabel(state.DeviceID), ErrDeviceTypeMismatch, state.DeviceType, d.DeviceType)
+	}
+
+	if known && c.autoRound && state.EffectiveFlags&FlagTargetTemp != 0 {
+		state.SetTemperature = d.NormalizeTemperature(state.SetTemperature, state.OperationMode)
+	}
+
+	if state.EffectiveFlags&FlagTargetTemp != 0 && state.SetTemperature == 0 && !(known && d.allowsZeroSetpoint(state.OperationMode)) {
+		return nil, fmt.Errorf("%s: %w", c.deviceLabel(state.DeviceID), ErrZeroSetTemperature)
+	}
+
 	// Determine the correct API endpoint based on DeviceType
 	var setURL string
 	switch state.DeviceType {
-	case 0: // ATA (Air-to-Air)
-		setURL = fmt.Sprintf("%s/Device/SetAta", baseURL)
-	// TODO: Add cases for ATW (1) and ERV (3) if needed later
+	case DeviceTypeAta:
+		setURL = c.url("/Device/SetAta")
+	// ATW devices are handled by SetAtwDeviceState
+	// TODO: Add a case for ERV (3) if needed later
 	default:
-		return nil, fmt.Errorf("unsupported device type for SetDeviceState: %d", state.DeviceType)
+		return nil, fmt.Errorf("SetDeviceState: %w: %d", ErrUnsupportedDeviceType, state.DeviceType)
+	}
+
+	var before *AtaDeviceState
+	if c.rollback {
+		var err error
+		if before, err = c.GetDeviceStateContext(ctx, state.DeviceID, state.BuildingID); err != nil {
+			return nil, fmt.Errorf("failed to read state for rollback: %w", err)
+		}
+	}
+
+	updatedState, err := c.postState(ctx, setURL, &state)
+	if err != nil {
+		return nil, err
 	}
 
-	jsonBody, err := json.Marshal(state)
+	if fields := unappliedFields(&state, updatedState, state.EffectiveFlags); len(fields) > 0 {
+		partial := &PartialApplyError{
+			DeviceID: state.DeviceID,
+			Fields:   fields,
+			State:    updatedState,
+			hint:     c.commandHint(state.DeviceID),
+		}
+		if before != nil {
+			if reverted := c.rollbackPartial(ctx, setURL, before, &state, partial); reverted != nil {
+				updatedState = reverted
+			}
+		}
+		return updatedState, partial
+	}
+
+	return updatedState, nil
+}
+
+// postState sends state to setURL and returns the state echoed by MELCloud, with
+// BuildingID and DeviceName filled in and the command flags cleared.
+func (c *Client) postState(ctx context.Context, setURL string, state *AtaDeviceState) (*AtaDeviceState, error) {
+	jsonBody, err := c.marshalState(state)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal set device state request body: %w", err)
 	}
 
-	req, err := http.NewRequest("POST", setURL, bytes.NewBuffer(jsonBody))
+	if err := c.checkDuplicate(state.DeviceID, jsonBody); err != nil {
+		return nil, fmt.Errorf("%s: %w", c.deviceLabel(state.DeviceID), err)
+	}
+
+	updatedState, err := c.sendState(ctx, setURL, state.DeviceID, jsonBody)
+	if err != nil {
+		return nil, err
+	}
+
+	// Add back BuildingID as it's not always present in the response
+	// (Use the ID from the input state as it won't change)
+	updatedState.BuildingID = state.BuildingID
+	if updatedState.DeviceName == "" {
+		updatedState.DeviceName = state.DeviceName
+	}
+	// Powered-off units can echo a zero setpoint; keep the one they still remember
+	if !updatedState.Power && updatedState.SetTemperature == 0 {
+		updatedState.SetTemperature = state.SetTemperature
+	}
+
+	// Make the returned state safe to reuse for the next command
+	updatedState.ResetEffectiveFlags()
+	updatedState.HasPendingCommand = false
+
+	return updatedState, nil
+}
+
+// sendState POSTs a SetAta payload for deviceID and decodes the state MELCloud echoes back.
+func (c *Client) sendState(ctx context.Context, setURL string, deviceID int, payload []byte) (*AtaDeviceState, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", setURL, bytes.NewReader(payload))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create set device state request: %w", err)
 	}
 	c.setHeaders(req)
 	req.Header.Set("Content-Type", "application/json")
 
-	resp, err := c.httpClient.Do(req)
+	// Parse the response, which should be the updated state
+	var updatedState AtaDeviceState
+	header, err := c.do(req, "set device state", c.stateTarget(&updatedState))
+	if header != nil {
+		// MELCloud received the command, even if the response was an error
+		c.recordCommand(deviceID, payload)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to execute set device state request: %w", err)
+		return nil, fmt.Errorf("%s%s: %w", c.deviceLabel(deviceID), c.commandHint(deviceID), err)
 	}
-	defer resp.Body.Close()
+	c.recordCommandSent(deviceID)
+	return &updatedState, nil
+}
 
-	if resp.StatusCode != http.StatusOK {
-		var errBody map[string]interface{}
-		if err := json.NewDecoder(resp.Body).Decode(&errBody); err == nil {
-			return nil, fmt.Errorf("set device state failed for device %d with status code: %d, details: %v", state.DeviceID, resp.StatusCode, errBody)
+// rollbackPartial reverts the fields of requested that did take effect to their values in
+// before, recording the outcome on partial. It returns the state echoed by the revert, or
+// nil if nothing had to be reverted or the revert failed to send.
+func (c *Client) rollbackPartial(ctx context.Context, setURL string, before, requested *AtaDeviceState, partial *PartialApplyError) *AtaDeviceState {
+	applied := requested.EffectiveFlags &^ unappliedFlags(requested, partial.State, requested.EffectiveFlags)
+	if applied == 0 {
+		partial.RolledBack = true
+		return nil
+	}
+
+	revert := *before
+	revert.EffectiveFlags = applied
+	revert.HasPendingCommand = true
+	echo, err := c.postState(ctx, setURL, &revert)
+	if err == nil {
+		if fields := unappliedFields(&revert, echo, applied); len(fields) > 0 {
+			err = fmt.Errorf("fields not reverted: %s", strings.Join(fields, ", "))
 		}
-		return nil, fmt.Errorf("set device state failed for device %d with status code: %d", state.DeviceID, resp.StatusCode)
 	}
+	partial.RolledBack = err == nil
+	partial.RollbackErr = err
+	return echo
+}
 
-	// Parse the response, which should be the updated state
-	var updatedState AtaDeviceState
-	if err := json.NewDecoder(resp.Body).Decode(&updatedState); err != nil {
-		return nil, fmt.Errorf("failed to decode set device state response for device %d: %w", state.DeviceID, err)
+// Do calls an arbitrary MELCloud API endpoint, for endpoints this library does not wrap yet.
+// path is relative to the API base URL (e.g. "/User/ListDevices"). A non-nil body is sent
+// as JSON, and the JSON response is decoded into out unless out is nil.
+// Requests get the same auth headers, pacing, size limits and error handling as the
+// built-in methods.
+func (c *Client) Do(ctx context.Context, method, path string, body interface{}, out interface{}) error {
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
 	}
 
-	// Add back BuildingID as it's not always present in the response
-	// (Use the ID from the input state as it won't change)
-	updatedState.BuildingID = state.BuildingID
+	var reqBody io.Reader
+	if body != nil {
+		jsonBody, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to marshal %s %s request body: %w", method, path, err)
+		}
+		reqBody = bytes.NewReader(jsonBody)
+	}
 
-	return &updatedState, nil
+	req, err := http.NewRequestWithContext(ctx, method, c.url(path), reqBody)
+	if err != nil {
+		return fmt.Errorf("failed to create %s %s request: %w", method, path, err)
+	}
+	c.setHeaders(req)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	return c.doJSON(req, method+" "+path, out)
 }
 
+// updateDeviceState fetches the current state of a device, applies update to it and
+// sends the result back with SetDeviceState.
+func (c *Client) updateDeviceState(ctx context.Context, deviceID, buildingID int, update func(*AtaDeviceState) error) (*AtaDeviceState, error) {
+	if err := c.checkControl(deviceID); err != nil {
+		return nil, err
+	}
+	state, err := c.GetDeviceStateContext(ctx, deviceID, buildingID)
+	if err != nil {
+		return nil, err
+	}
+	state.ResetEffectiveFlags()
+	if err := update(state); err != nil {
+		return nil, fmt.Errorf("%s: %w", c.deviceLabel(deviceID), err)
+	}
+	return c.SetDeviceStateContext(ctx, *state)
+}
+
+// SetDemandPercentage caps a device's capacity to pct percent (0-100) of its rating.
+func (c *Client) SetDemandPercentage(deviceID, buildingID int, pct int) error {
+	_, err := c.updateDeviceState(context.Background(), deviceID, buildingID, func(s *AtaDeviceState) error {
+		return s.SetDemandPercentage(pct)
+	})
+	return err
+}
+
+// SetNightMode turns a device's night (quiet) mode on or off. Units without night mode
+// ignore the setting, which is reported as a *PartialApplyError.
+func (c *Client) SetNightMode(deviceID, buildingID int, on bool) error {
+	_, err := c.updateDeviceState(context.Background(), deviceID, buildingID, func(s *AtaDeviceState) error {
+		s.SetNightMode(on)
+		return nil
+	})
+	return err
+}
+
+// flattenBuildings extracts the devices from the nested building structure, similar to
+// pymelcloud. Devices are stamped with the floor and area they were found in; a device
+// listed more than once is only kept where it is first found.
+func flattenBuildings(buildings []Building) []Device {
+	var n int
+	for i := range buildings {
+		structure := &buildings[i].Structure
+		n += len(structure.Devices)
+		for j := range structure.Areas {
+			n += len(structure.Areas[j].Devices)
+		}
+		for j := range structure.Floors {
+			floor := &structure.Floors[j]
+			n += len(floor.Devices)
+			for k := range floor.Areas {
+				n += len(floor.Areas[k].Devices)
+			}
+		}
+	}
+
+	allDevices := make([]Device, 0, n)
+	visited := make(map[int]struct{}, n)
+	add := func(devices []Device, floorID, areaID int) {
+		for i := range devices {
+			device := &devices[i] // Devices are large; avoid copying all of them twice
+			if _, found := visited[device.DeviceID]; found {
+				continue
+			}
+			visited[device.DeviceID] = struct{}{}
+			allDevices = append(allDevices, *device)
+			if floorID != 0 {
+				allDevices[len(allDevices)-1].FloorID = floorID
+			}
+			if areaID != 0 {
+				allDevices[len(allDevices)-1].AreaID = areaID
+			}
+		}
+	}
+
+	for i := range buildings {
+		structure := &buildings[i].Structure
+		add(structure.Devices, 0, 0)
+		for j := range structure.Areas {
+			area := &structure.Areas[j]
+			add(area.Devices, 0, area.ID)
+		}
+		for j := range structure.Floors {
+			floor := &structure.Floors[j]
+			add(floor.Devices, floor.ID, 0)
+			for k := range floor.Areas {
+				area := &floor.Areas[k]
+				add(area.Devices, floor.ID, area.ID)
+			}
+		}
+	}
+	return allDevices
+}
+
+// ServerTimeOffset estimates how far the host clock is from MELCloud's clock, using the
+// Date header of an authenticated request. A positive offset means the server is ahead.
+// The result is only accurate to about a second, the resolution of the Date header.
+func (c *Client) ServerTimeOffset(ctx context.Context) (time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.url("/User/ListDevices"), nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create server time request: %w", err)
+	}
+	c.setHeaders(req)
+
+	start := time.Now().UTC()
+	var ignored json.RawMessage
+	header, err := c.do(req, "server time", &ignored)
+	if err != nil {
+		return 0, err
+	}
+	// Assume the server stamped the response halfway through the round trip
+	local := start.Add(time.Since(start) / 2)
+
+	serverTime, err := http.ParseTime(header.Get("Date"))
+	if err != nil {
+		return 0, fmt.Errorf("server time response has no valid Date header: %w", err)
+	}
+	return serverTime.Sub(local), nil
+}
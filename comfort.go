@@ -0,0 +1,100 @@
+package melcloud
+
+import (
+	"math"
+	"time"
+)
+
+// comfortTolerance is how far (°C) the room may be from the setpoint to count as at setpoint.
+const comfortTolerance = 0.5
+
+// ComfortReport summarizes how well a unit held its setpoint over a series of samples.
+type ComfortReport struct {
+	TimeAtSetpoint   time.Duration // Powered time with the room within 0.5°C of the setpoint
+	PoweredTime      time.Duration // Time the unit was on
+	AverageDelta     float64       // Mean |room - setpoint| while powered, °C
+	AverageOvershoot float64       // Mean overshoot past the setpoint while powered, °C (heat: above, cool/dry: below)
+	Cycles           int           // Number of times the unit was switched back on after being off
+}
+
+// ComfortStats computes a ComfortReport from states sampled every interval, typically
+// successive GetDeviceState results. Each sample stands for one interval; samples taken
+// while the unit was off only count towards Cycles. Overshoot is only measured in heat,
+// cool and dry modes, where its direction is known.
+func ComfortStats(samples []AtaDeviceState, interval time.Duration) ComfortReport {
+	var report ComfortReport
+	var powered int
+	var delta, overshoot float64
+	for i, s := range samples {
+		if i > 0 && s.Power && !samples[i-1].Power {
+			report.Cycles++
+		}
+		if !s.Power {
+			continue
+		}
+
+		powered++
+		d := s.TemperatureDelta()
+		delta += math.Abs(d)
+		if s.AtSetpoint(comfortTolerance) {
+			report.TimeAtSetpoint += interval
+		}
+		switch s.OperationMode {
+		case OpModeHeat:
+			overshoot += math.Max(d, 0)
+		case OpModeCool, OpModeDry:
+			overshoot += math.Max(-d, 0)
+		}
+	}
+
+	report.PoweredTime = time.Duration(powered) * interval
+	if powered > 0 {
+		report.AverageDelta = delta / float64(powered)
+		report.AverageOvershoot = overshoot / float64(powered)
+	}
+	return report
+}
+
+// TempSample is a room temperature reading taken at a point in time.
+type TempSample struct {
+	Time        time.Time
+	Temperature float64 // °C
+}
+
+// EstimateTimeToSetpoint estimates how long until the room reaches target, from the
+// least-squares rate of change of the samples, extrapolated from the latest sample.
+// ok is false when there are fewer than two samples at distinct times or when the trend
+// moves away from (or not towards) target. A room already at target returns 0.
+// Samples must be in chronological order.
+func EstimateTimeToSetpoint(samples []TempSample, target float64) (eta time.Duration, ok bool) {
+	if len(samples) < 2 {
+		return 0, false
+	}
+	last := samples[len(samples)-1]
+	remaining := target - last.Temperature
+	if remaining == 0 {
+		return 0, true
+	}
+
+	// Fit temperature = a + rate*t, with t in seconds since the first sample
+	start := samples[0].Time
+	var sumT, sumY, sumTT, sumTY float64
+	for _, s := range samples {
+		t := s.Time.Sub(start).Seconds()
+		sumT += t
+		sumY += s.Temperature
+		sumTT += t * t
+		sumTY += t * s.Temperature
+	}
+	n := float64(len(samples))
+	denom := n*sumTT - sumT*sumT
+	if denom == 0 {
+		return 0, false
+	}
+	rate := (n*sumTY - sumT*sumY) / denom // °C per second
+
+	if rate == 0 || math.Signbit(rate) != math.Signbit(remaining) {
+		return 0, false
+	}
+	return time.Duration(remaining / rate * float64(time.Second)), true
+}
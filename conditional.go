@@ -0,0 +1,77 @@
+package melcloud
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// errNotModified is returned by doWith for a 304 response to a conditional request.
+var errNotModified = errors.New("not modified")
+
+// conditionalFallbackTTL is how long WithConditionalRequests reuses a device list whose
+// response carried no validators, so the option still saves requests when MELCloud ignores
+// conditional requests.
+const conditionalFallbackTTL = time.Minute
+
+// listValidators holds the cache validators of the last ListDevices response, with the
+// device list they belong to.
+type listValidators struct {
+	etag         string
+	lastModified string
+	devices      []Device
+	fetchedAt    time.Time
+}
+
+// setConditionalHeaders adds If-None-Match/If-Modified-Since to a ListDevices request when
+// WithConditionalRequests is enabled and a previous response carried validators. It returns
+// a copy of the device list to use if the server answers 304, or nil if none was added.
+func (c *Client) setConditionalHeaders(req *http.Request) []Device {
+	if !c.conditional {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v := c.listValidators
+	if v.etag == "" && v.lastModified == "" {
+		return nil
+	}
+	if v.etag != "" {
+		req.Header.Set("If-None-Match", v.etag)
+	}
+	if v.lastModified != "" {
+		req.Header.Set("If-Modified-Since", v.lastModified)
+	}
+	return slices.Clone(v.devices)
+}
+
+// rememberListValidators stores the validators of a ListDevices response.
+func (c *Client) rememberListValidators(header http.Header, devices []Device) {
+	if !c.conditional {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.listValidators = listValidators{
+		etag:         header.Get("ETag"),
+		lastModified: header.Get("Last-Modified"),
+		devices:      slices.Clone(devices),
+		fetchedAt:    c.now(),
+	}
+}
+
+// unvalidatedDeviceList returns a copy of the last device list if its response carried no
+// validators and it is younger than conditionalFallbackTTL.
+func (c *Client) unvalidatedDeviceList() ([]Device, bool) {
+	if !c.conditional {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v := c.listValidators
+	if v.devices == nil || v.etag != "" || v.lastModified != "" || c.now().Sub(v.fetchedAt) >= conditionalFallbackTTL {
+		return nil, false
+	}
+	return slices.Clone(v.devices), true
+}
@@ -0,0 +1,183 @@
+package melcloud
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Duration is a time.Duration that decodes from JSON as either a Go duration string
+// ("10s", "1m30s") or a number of nanoseconds.
+type Duration time.Duration
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		var n int64
+		if err := json.Unmarshal(data, &n); err != nil {
+			return fmt.Errorf("invalid duration %s", data)
+		}
+		*d = Duration(n)
+		return nil
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler, encoding the duration as a string.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Config gathers the client settings in one struct, for loading from a config file or the
+// environment. Zero values keep the defaults of the corresponding options. Every option
+// except WithTransport has a field.
+type Config struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+
+	BaseURL            string   `json:"base_url"`             // WithBaseURL
+	Timeout            Duration `json:"timeout"`              // WithTimeout
+	MaxResponseSize    int64    `json:"max_response_size"`    // WithMaxResponseSize
+	MinRequestInterval Duration `json:"min_request_interval"` // WithMinRequestInterval
+	MaxConcurrency     int      `json:"max_concurrency"`      // WithMaxConcurrency
+	MaxIdleConns       int      `json:"max_idle_conns"`       // WithMaxIdleConns
+	IdleConnTimeout    Duration `json:"idle_conn_timeout"`    // WithIdleConnTimeout
+	PollJitter         Duration `json:"poll_jitter"`          // WithPollJitter
+
+	// WithCircuitBreaker, enabled when BreakerThreshold > 0
+	BreakerThreshold int      `json:"breaker_threshold"`
+	BreakerCooldown  Duration `json:"breaker_cooldown"`
+
+	AutoRound             bool     `json:"auto_round"`              // WithAutoRound
+	PreserveUnknownFields bool     `json:"preserve_unknown_fields"` // WithPreserveUnknownFields
+	DuplicateSuppression  Duration `json:"duplicate_suppression"`   // WithDuplicateSuppression
+
+	ConditionalRequests    bool     `json:"conditional_requests"`      // WithConditionalRequests
+	DeviceListCache        Duration `json:"device_list_cache"`         // WithDeviceListCache
+	StrictJSON             bool     `json:"strict_json"`               // WithStrictJSON
+	RollbackOnPartialApply bool     `json:"rollback_on_partial_apply"` // WithRollbackOnPartialApply
+	PersistSession         *bool    `json:"persist_session"`           // WithPersistSession, nil keeps the default
+
+	// WithCassette, enabled when Cassette is set; CassetteMode is a RecordMode number
+	Cassette     string     `json:"cassette"`
+	CassetteMode RecordMode `json:"cassette_mode"`
+}
+
+// ConfigFromEnv reads a Config from MELCLOUD_* environment variables: MELCLOUD_EMAIL,
+// MELCLOUD_PASSWORD, MELCLOUD_BASE_URL, MELCLOUD_TIMEOUT, MELCLOUD_MIN_REQUEST_INTERVAL,
+// MELCLOUD_MAX_CONCURRENCY, MELCLOUD_DEVICE_LIST_CACHE and MELCLOUD_PERSIST_SESSION.
+// Durations use Go syntax ("10s") and booleans strconv.ParseBool syntax. Unset variables
+// are left zero; the remaining settings are only available from a config file.
+func ConfigFromEnv() (Config, error) {
+	cfg := Config{
+		Email:    os.Getenv("MELCLOUD_EMAIL"),
+		Password: os.Getenv("MELCLOUD_PASSWORD"),
+		BaseURL:  os.Getenv("MELCLOUD_BASE_URL"),
+	}
+	for name, dst := range map[string]*Duration{
+		"MELCLOUD_TIMEOUT":              &cfg.Timeout,
+		"MELCLOUD_MIN_REQUEST_INTERVAL": &cfg.MinRequestInterval,
+		"MELCLOUD_DEVICE_LIST_CACHE":    &cfg.DeviceListCache,
+	} {
+		if v := os.Getenv(name); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return Config{}, fmt.Errorf("invalid %s: %w", name, err)
+			}
+			*dst = Duration(d)
+		}
+	}
+	if v := os.Getenv("MELCLOUD_MAX_CONCURRENCY"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid MELCLOUD_MAX_CONCURRENCY: %w", err)
+		}
+		cfg.MaxConcurrency = n
+	}
+	if v := os.Getenv("MELCLOUD_PERSIST_SESSION"); v != "" {
+		persist, err := strconv.ParseBool(v)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid MELCLOUD_PERSIST_SESSION: %w", err)
+		}
+		cfg.PersistSession = &persist
+	}
+	return cfg, nil
+}
+
+// Options returns the client options corresponding to the non-zero settings in cfg.
+func (cfg Config) Options() []Option {
+	var opts []Option
+	if cfg.BaseURL != "" {
+		opts = append(opts, WithBaseURL(cfg.BaseURL))
+	}
+	if cfg.Timeout > 0 {
+		opts = append(opts, WithTimeout(time.Duration(cfg.Timeout)))
+	}
+	if cfg.MaxResponseSize != 0 {
+		opts = append(opts, WithMaxResponseSize(cfg.MaxResponseSize))
+	}
+	if cfg.MinRequestInterval > 0 {
+		opts = append(opts, WithMinRequestInterval(time.Duration(cfg.MinRequestInterval)))
+	}
+	if cfg.MaxConcurrency > 0 {
+		opts = append(opts, WithMaxConcurrency(cfg.MaxConcurrency))
+	}
+	if cfg.MaxIdleConns > 0 {
+		opts = append(opts, WithMaxIdleConns(cfg.MaxIdleConns))
+	}
+	if cfg.IdleConnTimeout > 0 {
+		opts = append(opts, WithIdleConnTimeout(time.Duration(cfg.IdleConnTimeout)))
+	}
+	if cfg.PollJitter > 0 {
+		opts = append(opts, WithPollJitter(time.Duration(cfg.PollJitter)))
+	}
+	if cfg.BreakerThreshold > 0 {
+		opts = append(opts, WithCircuitBreaker(cfg.BreakerThreshold, time.Duration(cfg.BreakerCooldown)))
+	}
+	if cfg.AutoRound {
+		opts = append(opts, WithAutoRound())
+	}
+	if cfg.PreserveUnknownFields {
+		opts = append(opts, WithPreserveUnknownFields())
+	}
+	if cfg.DuplicateSuppression > 0 {
+		opts = append(opts, WithDuplicateSuppression(time.Duration(cfg.DuplicateSuppression)))
+	}
+	if cfg.ConditionalRequests {
+		opts = append(opts, WithConditionalRequests())
+	}
+	if cfg.DeviceListCache > 0 {
+		opts = append(opts, WithDeviceListCache(time.Duration(cfg.DeviceListCache)))
+	}
+	if cfg.StrictJSON {
+		opts = append(opts, WithStrictJSON())
+	}
+	if cfg.RollbackOnPartialApply {
+		opts = append(opts, WithRollbackOnPartialApply())
+	}
+	if cfg.PersistSession != nil {
+		opts = append(opts, WithPersistSession(*cfg.PersistSession))
+	}
+	if cfg.Cassette != "" {
+		opts = append(opts, WithCassette(cfg.Cassette, cfg.CassetteMode))
+	}
+	return opts
+}
+
+// NewClientFromConfig logs in with the credentials in cfg and returns a client configured
+// with its settings. Additional options are applied after those from cfg.
+func NewClientFromConfig(cfg Config, opts ...Option) (*Client, error) {
+	if cfg.Email == "" || cfg.Password == "" {
+		return nil, fmt.Errorf("config must set email and password")
+	}
+	return login(context.Background(), cfg.Email, cfg.Password, append(cfg.Options(), opts...)...)
+}
@@ -0,0 +1,51 @@
+package melcloud
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// ConflictError is returned by SetDeviceStateIfUnchanged when the device's settings were
+// changed elsewhere (e.g. with the physical remote) since the caller read its baseline.
+type ConflictError struct {
+	DeviceID int
+	Changes  []FieldChange   // Settings that differ from the baseline
+	Current  *AtaDeviceState // State as fetched before the set
+	label    string
+}
+
+func (e *ConflictError) Error() string {
+	changes := make([]string, len(e.Changes))
+	for i, c := range e.Changes {
+		changes[i] = fmt.Sprintf("%s %v -> %v", c.Field, c.Old, c.New)
+	}
+	return fmt.Sprintf("%s changed since it was read: %s", e.label, strings.Join(changes, ", "))
+}
+
+// SetDeviceStateIfUnchanged makes read-modify-write safe against concurrent changes: it
+// re-fetches the device's state and only sends state if no setting differs from
+// baseline, the state the caller's changes were based on. Otherwise nothing is sent and a
+// *ConflictError lists the external changes; to proceed anyway, call SetDeviceState, or
+// re-apply the changes to ConflictError.Current and try again.
+// This costs one extra request per set, and a change made between the check and the set
+// can still be overwritten.
+func (c *Client) SetDeviceStateIfUnchanged(ctx context.Context, baseline *AtaDeviceState, state AtaDeviceState) (*AtaDeviceState, error) {
+	buildingID := state.BuildingID
+	if buildingID == 0 {
+		buildingID = baseline.BuildingID
+	}
+	current, err := c.GetDeviceStateContext(ctx, state.DeviceID, buildingID)
+	if err != nil {
+		return nil, err
+	}
+	if changes := Diff(baseline, current); len(changes) > 0 {
+		return nil, &ConflictError{
+			DeviceID: state.DeviceID,
+			Changes:  changes,
+			Current:  current,
+			label:    c.deviceLabel(state.DeviceID),
+		}
+	}
+	return c.SetDeviceStateContext(ctx, state)
+}
@@ -0,0 +1,42 @@
+package melcloud
+
+// Signal quality buckets returned in Connectivity.Quality.
+const (
+	SignalUnknown   = "unknown"
+	SignalExcellent = "excellent"
+	SignalGood      = "good"
+	SignalFair      = "fair"
+	SignalPoor      = "poor"
+)
+
+// Connectivity describes the health of a unit's WiFi adapter link.
+type Connectivity struct {
+	RSSI      int    // WifiSignalStrength in dBm, 0 when not reported
+	Quality   string // One of the Signal* constants
+	Connected bool   // The adapter is currently linked to MELCloud
+}
+
+// Connectivity combines the adapter's signal strength and link status.
+func (s *AtaDeviceState) Connectivity() Connectivity {
+	return Connectivity{
+		RSSI:      s.WifiSignalStrength,
+		Quality:   signalQuality(s.WifiSignalStrength),
+		Connected: !s.Offline,
+	}
+}
+
+// signalQuality buckets an RSSI in dBm using the usual WiFi thresholds.
+func signalQuality(rssi int) string {
+	switch {
+	case rssi == 0:
+		return SignalUnknown
+	case rssi >= -50:
+		return SignalExcellent
+	case rssi >= -60:
+		return SignalGood
+	case rssi >= -70:
+		return SignalFair
+	default:
+		return SignalPoor
+	}
+}
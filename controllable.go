@@ -0,0 +1,75 @@
+package melcloud
+
+import (
+	"context"
+	"time"
+)
+
+// controllableMaxAge is how old a device's last state communication may be before
+// Controllable reports it as offline.
+const controllableMaxAge = 15 * time.Minute
+
+// Controllable reports whether a command sent to the device now is expected to take effect,
+// combining access level, device lock-outs, offline detection, grid demand-response control
+// and pending commands. When it is not, reason explains why in a sentence suitable for users.
+// Capabilities come from ListDevices (cached with WithDeviceListCache) and the state from
+// one GetDeviceState call; err is only set if those requests fail.
+func (c *Client) Controllable(deviceID, buildingID int) (ok bool, reason string, err error) {
+	return c.ControllableContext(context.Background(), deviceID, buildingID)
+}
+
+// ControllableContext is like Controllable but honors the context for cancellation.
+func (c *Client) ControllableContext(ctx context.Context, deviceID, buildingID int) (ok bool, reason string, err error) {
+	d, known := c.knownDevice(deviceID)
+	if !known {
+		if _, err := c.ListDevicesContext(ctx); err != nil {
+			return false, "", err
+		}
+		if d, known = c.knownDevice(deviceID); !known {
+			return false, "device is not on this account", nil
+		}
+	}
+
+	if reason := deviceControlBlocker(&d); reason != "" {
+		return false, reason, nil
+	}
+
+	state, err := c.GetDeviceStateContext(ctx, deviceID, buildingID)
+	if err != nil {
+		return false, "", err
+	}
+	if reason := stateControlBlocker(state, c.now()); reason != "" {
+		return false, reason, nil
+	}
+	return true, "", nil
+}
+
+// deviceControlBlocker returns why the account or the device's lock-outs keep commands from
+// taking effect, or "" if they do not.
+func deviceControlBlocker(d *Device) string {
+	if !d.CanControl() {
+		return "the account only has view access to this device"
+	}
+	if d.ProhibitPower && d.ProhibitOperationMode && d.ProhibitSetTemperature {
+		if d.HasWiredController() {
+			return "all settings are locked by the wired controller"
+		}
+		return "all settings are locked"
+	}
+	return ""
+}
+
+// stateControlBlocker returns why the state at time now keeps commands from taking effect,
+// or "" if it does not.
+func stateControlBlocker(s *AtaDeviceState, now time.Time) string {
+	if s.IsOffline(now, controllableMaxAge) {
+		return "device is offline"
+	}
+	if s.UnderGridControl() {
+		return "device is curtailed by a grid demand-response signal"
+	}
+	if s.HasPendingCommand {
+		return "a previous command has not been applied yet"
+	}
+	return ""
+}
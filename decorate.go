@@ -0,0 +1,78 @@
+package melcloud
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// DecoratedState is a state together with the derived values a consumer typically needs,
+// with enums as the same strings the AtaDeviceState setters accept. It is meant to be sent
+// to integrations as JSON.
+type DecoratedState struct {
+	State *AtaDeviceState `json:"state"`
+
+	Power          string `json:"power"` // PowerOn, PowerOff or PowerStandby
+	Mode           string `json:"mode"`
+	FanSpeed       string `json:"fan_speed"`
+	VaneVertical   string `json:"vane_vertical"`
+	VaneHorizontal string `json:"vane_horizontal"`
+
+	// Setpoint control for the current mode; Min and Max are zero without a reported range
+	TemperatureMin  float64 `json:"temperature_min"`
+	TemperatureMax  float64 `json:"temperature_max"`
+	TemperatureStep float64 `json:"temperature_step"`
+
+	Controls     Controls `json:"controls"`     // Settings the device lets MELCloud change
+	Controllable bool     `json:"controllable"` // As reported by Client.Controllable
+	Faults       []Fault  `json:"faults,omitempty"`
+}
+
+// Decorate derives a DecoratedState from the state and the device's capabilities from
+// ListDevices. Controllable applies the same checks as Client.Controllable at the current
+// time. With a nil dev, the temperature bounds and controls are left zero and only the
+// state's checks (offline, grid control, pending command) decide Controllable.
+func (s *AtaDeviceState) Decorate(dev *Device) DecoratedState {
+	return s.decorate(dev, time.Now())
+}
+
+func (s *AtaDeviceState) decorate(dev *Device, now time.Time) DecoratedState {
+	d := DecoratedState{
+		State:          s,
+		Power:          s.PowerState(),
+		Mode:           s.OperationModeString(),
+		FanSpeed:       s.FanSpeedString(),
+		VaneVertical:   s.VaneVerticalString(),
+		VaneHorizontal: s.VaneHorizontalString(),
+		Controllable:   stateControlBlocker(s, now) == "",
+		Faults:         s.Faults(),
+	}
+	if dev != nil {
+		bounds := s.ActiveTempBounds(dev)
+		d.TemperatureMin, d.TemperatureMax, d.TemperatureStep = bounds.Min, bounds.Max, bounds.Step
+		d.Controls = dev.WritableControls()
+		d.Controllable = d.Controllable && deviceControlBlocker(dev) == ""
+	}
+	return d
+}
+
+// GetDecoratedState fetches a device's state and decorates it with the capabilities from
+// the last ListDevices call, listing devices first if the device is not known yet.
+func (c *Client) GetDecoratedState(ctx context.Context, deviceID, buildingID int) (*DecoratedState, error) {
+	if _, ok := c.knownDevice(deviceID); !ok {
+		if _, err := c.ListDevicesContext(ctx); err != nil {
+			return nil, err
+		}
+	}
+	dev, ok := c.knownDevice(deviceID)
+	if !ok {
+		return nil, fmt.Errorf("device %d not found on this account", deviceID)
+	}
+
+	state, err := c.GetDeviceStateContext(ctx, deviceID, buildingID)
+	if err != nil {
+		return nil, err
+	}
+	decorated := state.decorate(&dev, c.now())
+	return &decorated, nil
+}
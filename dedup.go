@@ -0,0 +1,47 @@
+package melcloud
+
+import (
+	"bytes"
+	"errors"
+	"time"
+)
+
+// ErrDuplicateCommand is returned by SetDeviceState when an identical command was sent to
+// the same device within the window set by WithDuplicateSuppression. The earlier command
+// may have been applied even if it returned an error; fetch the state to find out.
+var ErrDuplicateCommand = errors.New("identical command sent recently")
+
+// sentCommand is the last SetAta payload sent to a device.
+type sentCommand struct {
+	body []byte
+	at   time.Time
+}
+
+// checkDuplicate returns ErrDuplicateCommand if body was already sent to deviceID within
+// the suppression window.
+func (c *Client) checkDuplicate(deviceID int, body []byte) error {
+	if c.dedupWindow <= 0 {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if last, ok := c.lastCommands[deviceID]; ok && bytes.Equal(last.body, body) && c.now().Sub(last.at) < c.dedupWindow {
+		return ErrDuplicateCommand
+	}
+	return nil
+}
+
+// recordCommand records body as the last command sent to deviceID. It is only called once
+// MELCloud responded, so commands that were never sent (circuit open, throttled, cancelled
+// or failed to connect) can be retried right away.
+func (c *Client) recordCommand(deviceID int, body []byte) {
+	if c.dedupWindow <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lastCommands == nil {
+		c.lastCommands = make(map[int]sentCommand)
+	}
+	c.lastCommands[deviceID] = sentCommand{body: body, at: c.now()}
+}
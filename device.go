@@ -1,19 +1,67 @@
 package melcloud
 
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Device types as reported in Device.DeviceType.
+const (
+	DeviceTypeAta = 0 // Air-to-Air
+	DeviceTypeAtw = 1 // Air-to-Water (heat pump)
+	DeviceTypeErv = 3 // Energy Recovery Ventilation
+)
+
+// ErrUnsupportedDeviceType is returned when a call is made for the wrong device type.
+// ATA devices use GetDeviceState/SetDeviceState and ATW devices GetAtwDeviceState/SetAtwDeviceState;
+// ERV devices are not supported yet.
+var ErrUnsupportedDeviceType = errors.New("unsupported device type")
+
+// Access levels reported in Device.AccessLevel.
+const (
+	AccessLevelGuest = 3 // Shared with this account read-only
+	AccessLevelOwner = 4
+)
+
+// ErrReadOnlyAccess is returned by setters for devices this account can only view.
+var ErrReadOnlyAccess = errors.New("read-only access to device")
+
+// ErrDeviceTypeMismatch is returned by SetDeviceState when the state's DeviceType does not
+// match the type ListDevices reported for that device.
+var ErrDeviceTypeMismatch = errors.New("device type mismatch")
+
 // Device represents a generic MELCloud device.
 // Specific device types (ATA, ATW, ERV) will embed or reference this.
 type Device struct {
 	DeviceID           int    `json:"DeviceID"`
 	BuildingID         int    `json:"BuildingID"`
+	FloorID            int    `json:"FloorID"` // 0 when the device is not on a floor
+	AreaID             int    `json:"AreaID"`  // 0 when the device is not in an area
 	DeviceName         string `json:"DeviceName"`
-	MacAddress         string `json:"MacAddress"`
-	SerialNumber       string `json:"SerialNumber"`
+	MacAddress         string `json:"MacAddress"`   // MAC of the WiFi adapter, changes if the adapter is replaced
+	SerialNumber       string `json:"SerialNumber"` // Serial of the MELCloud adapter registration, kept across re-provisioning
 	AccessLevel        int    `json:"AccessLevel"`
 	DeviceType         int    `json:"DeviceType"`
 	WifiSignalStrength int    `json:"WifiSignalStrength"`
+	WiredController    bool   `json:"HasWiredController"` // A wired remote is attached and may override MELCloud
+	DualSetpoint       bool   `json:"HasDualSetpoint"`    // Separate heat/cool setpoints in heat_cool mode
+
+	// Device group for group commands, 0 when the device is not in one; see GroupID
+	Group int `json:"GroupID"`
 
-	// Configuration fields often nested under "Device" in pymelcloud
-	// These might be better handled by a separate capabilities/config struct
+	// Maintenance dates in the LastCommunication format (a date alone is also accepted),
+	// empty or null when not recorded; see InstalledAt and WarrantyExpires
+	InstallationDate string `json:"InstallationDate"`
+	RegistrationDate string `json:"RegistrationDate"`
+	LastServiceDate  string `json:"LastServiceDate"`
+	WarrantyEndDate  string `json:"WarrantyEndDate"`
+
+	// Configuration fields, nested under "Device" in the ListDevices response
+	// (merged into this struct by UnmarshalJSON)
 	TemperatureIncrement float64 `json:"TemperatureIncrement"`
 	MinTempHeat          float64 `json:"MinTempHeat"`
 	MaxTempHeat          float64 `json:"MaxTempHeat"`
@@ -21,7 +69,340 @@ type Device struct {
 	MaxTempCoolDry       float64 `json:"MaxTempCoolDry"`
 	MinTempAutomatic     float64 `json:"MinTempAutomatic"`
 	MaxTempAutomatic     float64 `json:"MaxTempAutomatic"`
+	NumberOfFanSpeeds    int     `json:"NumberOfFanSpeeds"`
+	MinFanSpeed          int     `json:"MinFanSpeed"` // Lowest fan speed, only reported by units not starting at 1
+	MaxFanSpeed          int     `json:"MaxFanSpeed"` // Highest fan speed, only reported by units with gaps in numbering
+	HasAutomaticFanSpeed bool    `json:"HasAutomaticFanSpeed"`
+
+	// Model capabilities and lock-outs, part of the nested configuration
+	ModelSupportsFanSpeed       bool `json:"ModelSupportsFanSpeed"`
+	ModelSupportsVaneVertical   bool `json:"ModelSupportsVaneVertical"`
+	ModelSupportsVaneHorizontal bool `json:"ModelSupportsVaneHorizontal"`
+	ProhibitPower               bool `json:"ProhibitPower"`          // Power locked by the installer or a wired controller
+	ProhibitOperationMode       bool `json:"ProhibitOperationMode"`  // Mode locked
+	ProhibitSetTemperature      bool `json:"ProhibitSetTemperature"` // Setpoint locked
+
+	// Fine-grained vertical vane angles, only on units that support more than the 1-5
+	// presets; part of the nested configuration. See AtaDeviceState.SetVaneVerticalAngle.
+	VaneVerticalAngles []VaneAngle `json:"VaneVerticalAngles"`
+
+	// Supported operation modes, part of the nested configuration; see CanCool etc.
+	HeatSupported bool `json:"CanHeat"`
+	CoolSupported bool `json:"CanCool"`
+	DrySupported  bool `json:"CanDry"`
+	AutoSupported bool `json:"CanAuto"`
+
+	// Adapter firmware update status, also part of the nested configuration
+	FirmwareDeployment    json.RawMessage `json:"FirmwareDeployment"` // null unless an update is scheduled
+	FirmwareUpdateAborted bool            `json:"FirmwareUpdateAborted"`
 	// Add other relevant conf fields...
+
+	autoFanReported bool // HasAutomaticFanSpeed was present in the decoded response
+}
+
+// UnmarshalJSON decodes a device entry from the ListDevices response. MELCloud nests the
+// unit's configuration (temperature ranges, increment, ...) in a "Device" object, which is
+// merged into the flat fields of Device. The nested object is decoded first, so fields
+// present in both places (DeviceID, DeviceName, MacAddress, ...) keep their top-level values.
+func (d *Device) UnmarshalJSON(data []byte) error {
+	var nested struct {
+		Conf json.RawMessage `json:"Device"`
+	}
+	if err := json.Unmarshal(data, &nested); err != nil {
+		return err
+	}
+	if len(nested.Conf) != 0 && string(nested.Conf) != "null" {
+		if err := d.decode(nested.Conf); err != nil {
+			return err
+		}
+	}
+
+	if err := d.decode(data); err != nil {
+		return err
+	}
+	d.MacAddress = NormalizeMAC(d.MacAddress)
+	return nil
+}
+
+// decode merges one JSON object into d, accepting integral floats for the fan speed counts
+// and recording whether HasAutomaticFanSpeed was present.
+func (d *Device) decode(data []byte) error {
+	type plain Device // Avoids recursing into UnmarshalJSON
+	aux := struct {
+		*plain
+		NumberOfFanSpeeds    flexInt `json:"NumberOfFanSpeeds"`
+		MinFanSpeed          flexInt `json:"MinFanSpeed"`
+		MaxFanSpeed          flexInt `json:"MaxFanSpeed"`
+		HasAutomaticFanSpeed *bool   `json:"HasAutomaticFanSpeed"`
+	}{
+		plain:             (*plain)(d),
+		NumberOfFanSpeeds: flexInt(d.NumberOfFanSpeeds),
+		MinFanSpeed:       flexInt(d.MinFanSpeed),
+		MaxFanSpeed:       flexInt(d.MaxFanSpeed),
+	}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	d.NumberOfFanSpeeds = int(aux.NumberOfFanSpeeds)
+	d.MinFanSpeed = int(aux.MinFanSpeed)
+	d.MaxFanSpeed = int(aux.MaxFanSpeed)
+	if aux.HasAutomaticFanSpeed != nil {
+		d.HasAutomaticFanSpeed = *aux.HasAutomaticFanSpeed
+		d.autoFanReported = true
+	}
+	return nil
+}
+
+// NormalizeMAC returns mac in canonical lower-case, colon-separated form
+// (e.g. "00:1a:2b:3c:4d:5e"). MELCloud reports MACs with colons, dashes, dots or no
+// separators depending on the endpoint. Values that are not 12 hex digits are only
+// lower-cased and trimmed.
+func NormalizeMAC(mac string) string {
+	mac = strings.ToLower(strings.TrimSpace(mac))
+	hex := strings.Map(func(r rune) rune {
+		switch r {
+		case ':', '-', '.', ' ':
+			return -1
+		}
+		return r
+	}, mac)
+	if len(hex) != 12 || strings.Trim(hex, "0123456789abcdef") != "" {
+		return mac
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(hex); i += 2 {
+		if i > 0 {
+			b.WriteByte(':')
+		}
+		b.WriteString(hex[i : i+2])
+	}
+	return b.String()
+}
+
+// StableID returns the preferred identifier for keying the device in other systems:
+// the serial number if present, else the MAC address, else the DeviceID.
+// DeviceID changes when a unit is removed and re-added to MELCloud, so it is the last resort.
+// The MAC address is normalized, so differently formatted spellings give the same ID.
+func (d *Device) StableID() string {
+	switch {
+	case d.SerialNumber != "":
+		return d.SerialNumber
+	case d.MacAddress != "":
+		return NormalizeMAC(d.MacAddress)
+	default:
+		return strconv.Itoa(d.DeviceID)
+	}
+}
+
+// CanControl reports whether this account may change the device's settings.
+// Guests of a shared device can only view it; an unreported access level is assumed to allow control.
+func (d *Device) CanControl() bool {
+	return d.AccessLevel != AccessLevelGuest
+}
+
+// HasWiredController reports whether a wired remote controller is attached to the unit.
+// Settings changed on a wired controller can override commands sent through MELCloud.
+func (d *Device) HasWiredController() bool {
+	return d.WiredController
+}
+
+// FirmwareUpdatePending reports whether MELCloud has a firmware update scheduled for the
+// unit's WiFi adapter that has not been installed or aborted yet.
+func (d *Device) FirmwareUpdatePending() bool {
+	if len(d.FirmwareDeployment) == 0 || string(d.FirmwareDeployment) == "null" {
+		return false
+	}
+	return !d.FirmwareUpdateAborted
+}
+
+// GroupID returns the MELCloud device group the device belongs to, and false if it is
+// not in a group. Grouped devices can be set together with a group command; areas
+// (AreaID) only organize devices and do not allow this.
+func (d *Device) GroupID() (int, bool) {
+	return d.Group, d.Group != 0
+}
+
+// InstalledAt returns when the unit was installed, or the zero time if it is not recorded.
+func (d *Device) InstalledAt() time.Time { return parseDate(d.InstallationDate) }
+
+// RegisteredAt returns when the unit was registered with MELCloud, or the zero time.
+func (d *Device) RegisteredAt() time.Time { return parseDate(d.RegistrationDate) }
+
+// LastServiceAt returns when the unit was last serviced, or the zero time.
+func (d *Device) LastServiceAt() time.Time { return parseDate(d.LastServiceDate) }
+
+// WarrantyExpires returns the end of the unit's warranty, or the zero time if unknown.
+func (d *Device) WarrantyExpires() time.Time { return parseDate(d.WarrantyEndDate) }
+
+// UnderWarranty reports whether the warranty is known and still runs at time now.
+func (d *Device) UnderWarranty(now time.Time) bool {
+	end := d.WarrantyExpires()
+	return !end.IsZero() && now.Before(end)
+}
+
+// parseDate parses an optional MELCloud date, returning the zero time for empty,
+// unparsable and .NET minimum ("0001-01-01") values.
+func parseDate(value string) time.Time {
+	if value == "" {
+		return time.Time{}
+	}
+	t, err := parseTimestamp(value)
+	if err != nil || t.Year() <= 1 {
+		return time.Time{}
+	}
+	return t
+}
+
+// VaneAngle maps a vertical vane angle to the raw VaneVertical value that selects it.
+type VaneAngle struct {
+	Degrees  int `json:"Angle"`
+	Position int `json:"Value"`
+}
+
+// Controls reports which settings of a device can be changed through MELCloud.
+type Controls struct {
+	Power    bool `json:"power"`
+	Mode     bool `json:"mode"`
+	Temp     bool `json:"temperature"`
+	FanSpeed bool `json:"fan_speed"`
+	VaneV    bool `json:"vane_vertical"`
+	VaneH    bool `json:"vane_horizontal"`
+}
+
+// WritableControls derives from the device's capabilities and lock-outs which controls
+// a UI should offer. Like the operation modes (see CanHeat), the ModelSupports* capabilities
+// are assumed to be supported when the ListDevices response reports none of them.
+func (d *Device) WritableControls() Controls {
+	unreported := !d.reportsModelSupport()
+	return Controls{
+		Power:    !d.ProhibitPower,
+		Mode:     !d.ProhibitOperationMode,
+		Temp:     !d.ProhibitSetTemperature,
+		FanSpeed: d.ModelSupportsFanSpeed || unreported,
+		VaneV:    d.ModelSupportsVaneVertical || unreported,
+		VaneH:    d.ModelSupportsVaneHorizontal || unreported,
+	}
+}
+
+// reportsModelSupport reports whether the device lists any ModelSupports* capability.
+// Older responses omit them all.
+func (d *Device) reportsModelSupport() bool {
+	return d.ModelSupportsFanSpeed || d.ModelSupportsVaneVertical || d.ModelSupportsVaneHorizontal
+}
+
+// reportsModes reports whether the device lists any supported operation modes. Older
+// responses omit them, in which case every mode is assumed to be supported.
+func (d *Device) reportsModes() bool {
+	return d.HeatSupported || d.CoolSupported || d.DrySupported || d.AutoSupported
+}
+
+// CanHeat reports whether the unit supports heat mode.
+func (d *Device) CanHeat() bool { return d.HeatSupported || !d.reportsModes() }
+
+// CanCool reports whether the unit supports cool mode. Heating-only units return false.
+func (d *Device) CanCool() bool { return d.CoolSupported || !d.reportsModes() }
+
+// CanDry reports whether the unit supports dry mode.
+func (d *Device) CanDry() bool { return d.DrySupported || !d.reportsModes() }
+
+// CanAuto reports whether the unit supports heat_cool (auto) mode.
+func (d *Device) CanAuto() bool { return d.AutoSupported || !d.reportsModes() }
+
+// SupportedModes returns the Mode* strings the unit accepts. Fan-only is always included.
+func (d *Device) SupportedModes() []string {
+	var modes []string
+	for _, m := range []struct {
+		mode string
+		ok   bool
+	}{
+		{ModeHeat, d.CanHeat()},
+		{ModeDry, d.CanDry()},
+		{ModeCool, d.CanCool()},
+		{ModeFanOnly, true},
+		{ModeHeatCool, d.CanAuto()},
+	} {
+		if m.ok {
+			modes = append(modes, m.mode)
+		}
+	}
+	return modes
+}
+
+// defaultTemperatureIncrement is used when a device does not report its TemperatureIncrement.
+const defaultTemperatureIncrement = 0.5
+
+// TemperatureStep returns the setpoint increment supported by the device.
+func (d *Device) TemperatureStep() float64 {
+	if d.TemperatureIncrement > 0 {
+		return d.TemperatureIncrement
+	}
+	return defaultTemperatureIncrement
+}
+
+// SupportsHalfDegree reports whether the device accepts setpoints in 0.5 degree steps.
+// Units that only accept whole degrees reject half-degree setpoints.
+func (d *Device) SupportsHalfDegree() bool {
+	return d.TemperatureStep() < 1
+}
+
+// RoundTemperature rounds temp to the nearest setpoint the device accepts:
+// half degrees for most units, whole degrees for units that don't support halves.
+func (d *Device) RoundTemperature(temp float64) float64 {
+	step := d.TemperatureStep()
+	return math.Round(temp/step) * step
+}
+
+// TemperatureRange returns the allowed setpoint range for an operation mode (OpMode* constant).
+// ok is false when the device reports no range for that mode.
+func (d *Device) TemperatureRange(mode int) (min, max float64, ok bool) {
+	switch mode {
+	case OpModeHeat:
+		min, max = d.MinTempHeat, d.MaxTempHeat
+	case OpModeCool, OpModeDry:
+		min, max = d.MinTempCoolDry, d.MaxTempCoolDry
+	case OpModeHeatCool:
+		min, max = d.MinTempAutomatic, d.MaxTempAutomatic
+	default:
+		return 0, 0, false
+	}
+	return min, max, max > min
+}
+
+// allowsZeroSetpoint reports whether the device's setpoint range for mode includes 0,
+// the only case where a zero SetTemperature is a genuine setpoint.
+func (d *Device) allowsZeroSetpoint(mode int) bool {
+	min, _, ok := d.TemperatureRange(mode)
+	return ok && min <= 0
+}
+
+// FanSpeedBounds returns the lowest and highest manual fan speed the device accepts and
+// whether it supports automatic fan speed. max is 0 when the device reports no fan speeds;
+// auto is then assumed to be supported, as it is when HasAutomaticFanSpeed is missing from
+// the response, which includes a Device built in code rather than decoded.
+func (d *Device) FanSpeedBounds() (min, max int, auto bool) {
+	max = d.MaxFanSpeed
+	if max == 0 {
+		max = d.NumberOfFanSpeeds
+	}
+	if max == 0 {
+		return 0, 0, true
+	}
+	min = d.MinFanSpeed
+	if min == 0 {
+		min = 1
+	}
+	return min, max, d.HasAutomaticFanSpeed || !d.autoFanReported
+}
+
+// NormalizeTemperature rounds temp to the device's increment and clamps it to the
+// setpoint range for mode (OpMode* constant), when the device reports one.
+func (d *Device) NormalizeTemperature(temp float64, mode int) float64 {
+	temp = d.RoundTemperature(temp)
+	if min, max, ok := d.TemperatureRange(mode); ok {
+		temp = math.Max(min, math.Min(max, temp))
+	}
+	return temp
 }
 
 // TODO: Potentially add methods to Device to fetch capabilities if needed.
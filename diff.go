@@ -0,0 +1,64 @@
+package melcloud
+
+// FieldChange is a setting that differs between two states of a device.
+type FieldChange struct {
+	Field string // MELCloud field name, e.g. "SetTemperature"
+	Old   interface{}
+	New   interface{}
+}
+
+// Diff returns the settings (the fields SetDeviceState can change) that differ from old
+// to new, in a fixed order. Readings such as RoomTemperature are not compared.
+func Diff(old, new *AtaDeviceState) []FieldChange {
+	var changes []FieldChange
+	for _, f := range settingFields {
+		if o, n := f.value(old), f.value(new); o != n {
+			changes = append(changes, FieldChange{Field: f.name, Old: o, New: n})
+		}
+	}
+	return changes
+}
+
+// DeviceDiff lists what changed for one device between two snapshots.
+type DeviceDiff struct {
+	DeviceID   int
+	DeviceName string
+	Added      bool // Only in the new snapshot
+	Removed    bool // Only in the old snapshot
+	Changes    []FieldChange
+}
+
+// DiffSnapshots compares two account snapshots (see Client.Snapshot) and returns the
+// devices whose settings changed, were added or were removed: changed and added devices in
+// the order of new, then removed devices in the order of old. Devices whose state could
+// not be fetched in either snapshot are skipped, since nothing can be said about them.
+func DiffSnapshots(old, new []DeviceSnapshot) []DeviceDiff {
+	previous := make(map[int]*DeviceSnapshot, len(old))
+	for i := range old {
+		previous[old[i].Device.DeviceID] = &old[i]
+	}
+
+	var diffs []DeviceDiff
+	seen := make(map[int]bool, len(new))
+	for _, snap := range new {
+		id := snap.Device.DeviceID
+		seen[id] = true
+		prev, ok := previous[id]
+		if !ok {
+			diffs = append(diffs, DeviceDiff{DeviceID: id, DeviceName: snap.Device.DeviceName, Added: true})
+			continue
+		}
+		if prev.State == nil || snap.State == nil {
+			continue
+		}
+		if changes := Diff(prev.State, snap.State); len(changes) > 0 {
+			diffs = append(diffs, DeviceDiff{DeviceID: id, DeviceName: snap.Device.DeviceName, Changes: changes})
+		}
+	}
+	for _, snap := range old {
+		if !seen[snap.Device.DeviceID] {
+			diffs = append(diffs, DeviceDiff{DeviceID: snap.Device.DeviceID, DeviceName: snap.Device.DeviceName, Removed: true})
+		}
+	}
+	return diffs
+}
@@ -0,0 +1,96 @@
+package melcloud
+
+import "fmt"
+
+// ErrorCodeNone is reported in ErrorCode when the unit has no fault.
+const ErrorCodeNone = 8000
+
+// AtaErrorCodes maps ATA ErrorCode values to descriptions. The codes follow the
+// Mitsubishi Electric check codes shown on wired remotes and service tools.
+var AtaErrorCodes = map[int]string{
+	ErrorCodeNone: "No error",
+	1102:          "Compressor discharge temperature too high",
+	1108:          "Compressor internal thermostat tripped",
+	1300:          "Low pressure fault",
+	1302:          "High pressure fault",
+	1500:          "Refrigerant overcharge",
+	1501:          "Refrigerant shortage",
+	2500:          "Water leakage detected",
+	2502:          "Drain pump fault",
+	2503:          "Drain sensor fault",
+	4100:          "Compressor current interruption (locked compressor)",
+	4115:          "Power supply synchronization signal fault",
+	4116:          "Indoor fan speed fault",
+	4210:          "Compressor overcurrent",
+	4220:          "Inverter bus voltage fault",
+	4230:          "Heatsink overheating",
+	4250:          "Power module fault",
+	4400:          "Outdoor fan fault",
+	5101:          "Room temperature thermistor fault",
+	5102:          "Indoor liquid pipe thermistor fault",
+	5103:          "Indoor gas pipe thermistor fault",
+	5105:          "Outdoor pipe thermistor fault",
+	5106:          "Outdoor air thermistor fault",
+	5110:          "Heatsink thermistor fault",
+	5300:          "Current sensor fault",
+	6600:          "Duplicate address",
+	6602:          "Transmission processor hardware fault",
+	6603:          "Transmission bus busy",
+	6606:          "Communication error with transmission processor",
+	6607:          "No acknowledgement from unit",
+	6608:          "No response from unit",
+	6831:          "Remote controller signal not received",
+	6832:          "Remote controller synchronization fault",
+	6840:          "Indoor/outdoor unit communication error",
+	7100:          "Total capacity error",
+	7101:          "Capacity code error",
+	7102:          "Too many connected units",
+	7105:          "Address setting error",
+	7111:          "Remote controller sensor fault",
+}
+
+// FaultText returns a description of an ATA error code, or a generic text for unknown codes.
+func FaultText(code int) string {
+	if text, ok := AtaErrorCodes[code]; ok {
+		return text
+	}
+	return fmt.Sprintf("Unknown error code %d", code)
+}
+
+// FaultDescription describes the unit's current fault, or returns "" if it has none.
+func (s *AtaDeviceState) FaultDescription() string {
+	if !s.HasError || s.ErrorCode == ErrorCodeNone {
+		return ""
+	}
+	return FaultText(s.ErrorCode)
+}
+
+// Fault is one active fault of a unit.
+type Fault struct {
+	Code        int
+	Description string
+}
+
+// Faults returns all active faults: the codes in ErrorCodes for units that report several
+// simultaneous faults, and ErrorCode otherwise. It returns nil if the unit has no fault.
+func (s *AtaDeviceState) Faults() []Fault {
+	var faults []Fault
+	add := func(code int) {
+		if code == 0 || code == ErrorCodeNone {
+			return
+		}
+		for _, f := range faults {
+			if f.Code == code {
+				return
+			}
+		}
+		faults = append(faults, Fault{Code: code, Description: FaultText(code)})
+	}
+	if s.HasError {
+		add(s.ErrorCode)
+	}
+	for _, code := range s.ErrorCodes {
+		add(code)
+	}
+	return faults
+}
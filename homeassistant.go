@@ -0,0 +1,121 @@
+package melcloud
+
+import "fmt"
+
+// Home Assistant climate constants not shared with MELCloud.
+// HA's remaining hvac_mode values ("heat", "cool", ...) match the Mode* constants.
+const (
+	HAModeOff = "off"
+
+	HASwingOff        = "off"
+	HASwingVertical   = "vertical"
+	HASwingHorizontal = "horizontal"
+	HASwingBoth       = "both"
+)
+
+// HAClimate is a device state expressed in Home Assistant climate entity terms.
+type HAClimate struct {
+	HVACMode           string  // "off" or one of the Mode* constants
+	FanMode            string  // "auto" or a speed ("1", "2", ...)
+	SwingMode          string  // One of the HASwing* constants
+	TargetTemperature  float64 // Setpoint in °C
+	CurrentTemperature float64 // Room temperature in °C (read-only)
+}
+
+// ToHAClimate converts a MELCloud state to Home Assistant climate semantics.
+// A powered-off unit is reported with HVACMode "off", regardless of its operation mode.
+func ToHAClimate(s *AtaDeviceState) HAClimate {
+	c := HAClimate{
+		HVACMode:           s.OperationModeString(),
+		FanMode:            s.FanSpeedString(),
+		SwingMode:          HASwingOff,
+		TargetTemperature:  s.SetTemperature,
+		CurrentTemperature: s.RoomTemperature,
+	}
+	if !s.Power {
+		c.HVACMode = HAModeOff
+	}
+
+	vertical := s.VaneVertical == VaneVertSwing
+	horizontal := s.VaneHorizontal == VaneHorizSwing
+	switch {
+	case vertical && horizontal:
+		c.SwingMode = HASwingBoth
+	case vertical:
+		c.SwingMode = HASwingVertical
+	case horizontal:
+		c.SwingMode = HASwingHorizontal
+	}
+	return c
+}
+
+// ApplyHAClimate applies a Home Assistant climate state to a MELCloud state, setting
+// flags only for values that differ. Empty strings and a zero target temperature are
+// left unchanged, so partial updates from HA service calls can be applied directly.
+// Turning swing off for a vane sets it to "auto". If any value is invalid, an error is
+// returned and state is left unchanged.
+func ApplyHAClimate(state *AtaDeviceState, c HAClimate) error {
+	next := *state // Only assigned back once every value was applied
+	switch c.HVACMode {
+	case "":
+	case HAModeOff:
+		if next.Power {
+			next.SetPower(false)
+		}
+	default:
+		if !next.Power {
+			next.SetPower(true)
+		}
+		if c.HVACMode != next.OperationModeString() {
+			if err := next.SetOperationMode(c.HVACMode); err != nil {
+				return err
+			}
+		}
+	}
+
+	if c.FanMode != "" && c.FanMode != next.FanSpeedString() {
+		if err := next.SetFanSpeedMode(c.FanMode); err != nil {
+			return err
+		}
+	}
+
+	if c.SwingMode != "" {
+		var vertical, horizontal bool
+		switch c.SwingMode {
+		case HASwingOff:
+		case HASwingVertical:
+			vertical = true
+		case HASwingHorizontal:
+			horizontal = true
+		case HASwingBoth:
+			vertical, horizontal = true, true
+		default:
+			return fmt.Errorf("invalid swing mode: %s", c.SwingMode)
+		}
+		if vertical != (next.VaneVertical == VaneVertSwing) {
+			pos := VaneAuto
+			if vertical {
+				pos = VaneSwing
+			}
+			if err := next.SetVaneVertical(pos); err != nil {
+				return err
+			}
+		}
+		if horizontal != (next.VaneHorizontal == VaneHorizSwing) {
+			pos := VaneAuto
+			if horizontal {
+				pos = VaneSwing
+			}
+			if err := next.SetVaneHorizontal(pos); err != nil {
+				return err
+			}
+		}
+	}
+
+	if c.TargetTemperature != 0 && c.TargetTemperature != next.SetTemperature {
+		next.SetTargetTemperature(c.TargetTemperature)
+	}
+
+	*state = next
+	return nil
+}
@@ -0,0 +1,65 @@
+package melcloud
+
+import "fmt"
+
+// DeviceIndex maps devices by their common identifiers. ByMAC is keyed by NormalizeMAC form.
+// When several devices share a name or MAC address, the first one listed wins.
+type DeviceIndex struct {
+	ByID   map[int]Device
+	ByName map[string]Device
+	ByMAC  map[string]Device
+}
+
+// newDeviceIndex builds an index over devices.
+func newDeviceIndex(devices []Device) *DeviceIndex {
+	idx := &DeviceIndex{
+		ByID:   make(map[int]Device, len(devices)),
+		ByName: make(map[string]Device, len(devices)),
+		ByMAC:  make(map[string]Device, len(devices)),
+	}
+	for _, d := range devices {
+		if _, ok := idx.ByID[d.DeviceID]; !ok {
+			idx.ByID[d.DeviceID] = d
+		}
+		if _, ok := idx.ByName[d.DeviceName]; !ok && d.DeviceName != "" {
+			idx.ByName[d.DeviceName] = d
+		}
+		mac := NormalizeMAC(d.MacAddress)
+		if _, ok := idx.ByMAC[mac]; !ok && mac != "" {
+			idx.ByMAC[mac] = d
+		}
+	}
+	return idx
+}
+
+// FindDeviceByMAC looks up a device by MAC address in any common format.
+func (idx *DeviceIndex) FindDeviceByMAC(mac string) (Device, bool) {
+	d, ok := idx.ByMAC[NormalizeMAC(mac)]
+	return d, ok
+}
+
+// BuildIndex lists the account's devices once and indexes them by ID, name and MAC address.
+// Callers can keep the index to resolve devices without listing them again.
+func (c *Client) BuildIndex() (*DeviceIndex, error) {
+	devices, err := c.ListDevices()
+	if err != nil {
+		return nil, err
+	}
+	return newDeviceIndex(devices), nil
+}
+
+// GetDeviceStateByID fetches the state of a device without the caller knowing its BuildingID.
+// The building is looked up from the last ListDevices result, listing devices if needed.
+func (c *Client) GetDeviceStateByID(deviceID int) (*AtaDeviceState, error) {
+	d, ok := c.knownDevice(deviceID)
+	if !ok {
+		idx, err := c.BuildIndex()
+		if err != nil {
+			return nil, err
+		}
+		if d, ok = idx.ByID[deviceID]; !ok {
+			return nil, fmt.Errorf("device %d not found on this account", deviceID)
+		}
+	}
+	return c.GetDeviceState(d.DeviceID, d.BuildingID)
+}
@@ -1,10 +1,34 @@
 package melcloud
 
 import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
+// newTestClient starts a fake MELCloud server with the given handler and returns
+// an authenticated client pointed at it.
+func newTestClient(t *testing.T, handler http.HandlerFunc, opts ...Option) *Client {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	c := newClient(append([]Option{WithBaseURL(srv.URL)}, opts...)...)
+	c.token = "test-token"
+	return c
+}
+
 // TestLogin requires MELCLOUD_EMAIL and MELCLOUD_PASSWORD environment variables to be set.
 func TestLogin(t *testing.T) {
 	if os.Getenv("MELCLOUD_EMAIL") == "" || os.Getenv("MELCLOUD_PASSWORD") == "" {
@@ -25,6 +49,202 @@ func TestLogin(t *testing.T) {
 	t.Logf("Login successful, token starts with: %s...", client.token[:min(10, len(client.token))])
 }
 
+func TestLoginContextDeadline(t *testing.T) {
+	t.Setenv("MELCLOUD_EMAIL", "user@example.com")
+	t.Setenv("MELCLOUD_PASSWORD", "secret")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte(`{"ErrorId":null,"LoginData":{"ContextKey":"abc"}}`))
+	}))
+	defer srv.Close()
+
+	// The login is slower than the client timeout but within the context deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	client, err := LoginContext(ctx, WithBaseURL(srv.URL), WithTimeout(50*time.Millisecond))
+	if err != nil {
+		t.Fatalf("LoginContext failed: %v", err)
+	}
+	if client.token != "abc" || client.httpClient.Timeout != 50*time.Millisecond {
+		t.Errorf("token = %q, timeout = %v", client.token, client.httpClient.Timeout)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := LoginContext(ctx, WithBaseURL(srv.URL)); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline exceeded, got %v", err)
+	}
+}
+
+func TestLoginErrorId(t *testing.T) {
+	t.Setenv("MELCLOUD_EMAIL", "user@example.com")
+	t.Setenv("MELCLOUD_PASSWORD", "secret")
+
+	tests := []struct {
+		name    string
+		errorID string
+		wantErr bool
+	}{
+		{"null", `null`, false},
+		{"zero", `0`, false},
+		{"empty string", `""`, false},
+		{"bad credentials", `1`, true},
+		{"error string", `"AccountLocked"`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(`{"ErrorId":` + tt.errorID + `,"LoginData":{"ContextKey":"abc"}}`))
+			}))
+			defer srv.Close()
+
+			_, err := Login(WithBaseURL(srv.URL))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Login() error = %v, wantErr %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewClientFromConfig(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"ErrorId":null,"LoginData":{"ContextKey":"abc"}}`))
+	}))
+	defer srv.Close()
+
+	var cfg Config
+	data := `{"email":"user@example.com","password":"secret","base_url":"` + srv.URL + `","timeout":"3s","min_request_interval":500000000,"max_concurrency":2}`
+	if err := json.Unmarshal([]byte(data), &cfg); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+
+	c, err := NewClientFromConfig(cfg)
+	if err != nil {
+		t.Fatalf("NewClientFromConfig failed: %v", err)
+	}
+	if c.token != "abc" || c.httpClient.Timeout != 3*time.Second || c.minRequestInterval != 500*time.Millisecond || c.maxConcurrency != 2 {
+		t.Errorf("client not configured from config: timeout %v, interval %v, concurrency %d", c.httpClient.Timeout, c.minRequestInterval, c.maxConcurrency)
+	}
+
+	if _, err := NewClientFromConfig(Config{BaseURL: srv.URL}); err == nil {
+		t.Error("expected error for config without credentials")
+	}
+
+	data = `{"conditional_requests":true,"device_list_cache":"5m","strict_json":true,"rollback_on_partial_apply":true,
+		"persist_session":false,"max_idle_conns":8,"idle_conn_timeout":"30s"}`
+	cfg = Config{}
+	if err := json.Unmarshal([]byte(data), &cfg); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	c = newClient(cfg.Options()...)
+	if !c.conditional || c.listCacheTTL != 5*time.Minute || !c.strictJSON || !c.rollback || c.persistSession || c.maxIdleConns != 8 || c.idleConnTimeout != 30*time.Second {
+		t.Errorf("options not applied from config: %+v", cfg)
+	}
+	if c := newClient(Config{}.Options()...); !c.persistSession {
+		t.Error("unset persist_session should keep the default")
+	}
+}
+
+func TestConfigFromEnv(t *testing.T) {
+	t.Setenv("MELCLOUD_EMAIL", "user@example.com")
+	t.Setenv("MELCLOUD_PASSWORD", "secret")
+	t.Setenv("MELCLOUD_BASE_URL", "http://localhost")
+	t.Setenv("MELCLOUD_TIMEOUT", "3s")
+	t.Setenv("MELCLOUD_MIN_REQUEST_INTERVAL", "500ms")
+	t.Setenv("MELCLOUD_MAX_CONCURRENCY", "2")
+	t.Setenv("MELCLOUD_DEVICE_LIST_CACHE", "10m")
+	t.Setenv("MELCLOUD_PERSIST_SESSION", "false")
+
+	cfg, err := ConfigFromEnv()
+	if err != nil {
+		t.Fatalf("ConfigFromEnv failed: %v", err)
+	}
+	if cfg.Email != "user@example.com" || cfg.Password != "secret" || cfg.BaseURL != "http://localhost" ||
+		cfg.Timeout != Duration(3*time.Second) || cfg.MinRequestInterval != Duration(500*time.Millisecond) ||
+		cfg.MaxConcurrency != 2 || cfg.DeviceListCache != Duration(10*time.Minute) ||
+		cfg.PersistSession == nil || *cfg.PersistSession {
+		t.Errorf("unexpected config: %+v", cfg)
+	}
+
+	for name, value := range map[string]string{
+		"MELCLOUD_TIMEOUT":         "soon",
+		"MELCLOUD_MAX_CONCURRENCY": "many",
+		"MELCLOUD_PERSIST_SESSION": "maybe",
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv(name, value)
+			if _, err := ConfigFromEnv(); err == nil || !strings.Contains(err.Error(), name) {
+				t.Errorf("err = %v, want an error naming %s", err, name)
+			}
+		})
+	}
+}
+
+func TestPersistSession(t *testing.T) {
+	t.Setenv("MELCLOUD_EMAIL", "user@example.com")
+	t.Setenv("MELCLOUD_PASSWORD", "secret")
+
+	var persist interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		persist = body["Persist"]
+		w.Write([]byte(`{"ErrorId":null,"LoginMinutes":30,"LoginData":{"ContextKey":"abc"}}`))
+	}))
+	defer srv.Close()
+
+	before := time.Now()
+	c, err := Login(WithBaseURL(srv.URL), WithPersistSession(false))
+	if err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+	if persist != false {
+		t.Errorf("sent Persist = %v, want false", persist)
+	}
+	if expiry, ok := c.SessionExpiry(); !ok || expiry.Before(before.Add(30*time.Minute)) {
+		t.Errorf("SessionExpiry() = %v, %t; want about 30 minutes from now", expiry, ok)
+	}
+
+	if _, err := Login(WithBaseURL(srv.URL)); err != nil || persist != true {
+		t.Errorf("default login: err = %v, Persist = %v", err, persist)
+	}
+}
+
+func TestValidateCredentials(t *testing.T) {
+	var loggedOut bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/Login/Logout":
+			loggedOut = true
+		case "/Login/ClientLogin":
+			var body struct{ Password string }
+			json.NewDecoder(r.Body).Decode(&body)
+			switch body.Password {
+			case "right":
+				w.Write([]byte(`{"ErrorId":null,"LoginData":{"ContextKey":"abc"}}`))
+			case "wrong":
+				w.Write([]byte(`{"ErrorId":1,"LoginData":null}`))
+			default:
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+		}
+	}))
+	defer srv.Close()
+
+	if ok, err := validateCredentials("user@example.com", "right", WithBaseURL(srv.URL)); !ok || err != nil {
+		t.Errorf("valid credentials: got (%t, %v), want (true, nil)", ok, err)
+	}
+	if !loggedOut {
+		t.Error("session was not logged out")
+	}
+	if ok, err := validateCredentials("user@example.com", "wrong", WithBaseURL(srv.URL)); ok || err != nil {
+		t.Errorf("invalid credentials: got (%t, %v), want (false, nil)", ok, err)
+	}
+	if ok, err := validateCredentials("user@example.com", "unavailable", WithBaseURL(srv.URL)); ok || err == nil {
+		t.Errorf("server error: got (%t, %v), want (false, error)", ok, err)
+	}
+}
+
 // TestListDevices requires MELCLOUD_EMAIL and MELCLOUD_PASSWORD environment variables to be set.
 func TestListDevices(t *testing.T) {
 	if os.Getenv("MELCLOUD_EMAIL") == "" || os.Getenv("MELCLOUD_PASSWORD") == "" {
@@ -185,10 +405,2717 @@ func TestSetDeviceState(t *testing.T) {
 	}
 }
 
-// Helper function for logging token prefix
-func min(a, b int) int {
-	if a < b {
-		return a
+func TestListDevicesFlattening(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"Structure":{
+			"Devices":[{"DeviceID":1}],
+			"Areas":[{"ID":10,"Devices":[{"DeviceID":2},{"DeviceID":1}]}],
+			"Floors":[{"ID":100,"Devices":[{"DeviceID":3}],"Areas":[{"ID":11,"Devices":[{"DeviceID":4}]}]}]
+		}}]`))
+	})
+
+	devices, err := c.ListDevices()
+	if err != nil {
+		t.Fatalf("ListDevices failed: %v", err)
+	}
+
+	want := []struct{ id, floor, area int }{{1, 0, 0}, {2, 0, 10}, {3, 100, 0}, {4, 100, 11}}
+	if len(devices) != len(want) {
+		t.Fatalf("got %d devices, want %d", len(devices), len(want))
+	}
+	for i, w := range want {
+		d := devices[i]
+		if d.DeviceID != w.id || d.FloorID != w.floor || d.AreaID != w.area {
+			t.Errorf("device %d = {ID:%d Floor:%d Area:%d}, want %+v", i, d.DeviceID, d.FloorID, d.AreaID, w)
+		}
+	}
+
+	inArea, err := c.ListDevicesInArea(11)
+	if err != nil {
+		t.Fatalf("ListDevicesInArea failed: %v", err)
+	}
+	if len(inArea) != 1 || inArea[0].DeviceID != 4 {
+		t.Errorf("ListDevicesInArea(11) = %+v, want device 4 only", inArea)
+	}
+}
+
+func TestTolerantNumbers(t *testing.T) {
+	for _, data := range []string{
+		`{"OperationMode":3,"SetFanSpeed":2,"VaneVertical":7,"DemandPercentage":80,"SetTemperature":22}`,
+		`{"OperationMode":3.0,"SetFanSpeed":2.0,"VaneVertical":7.0,"DemandPercentage":80.0,"SetTemperature":22.0}`,
+	} {
+		var s AtaDeviceState
+		if err := json.Unmarshal([]byte(data), &s); err != nil {
+			t.Fatalf("Unmarshal(%s) failed: %v", data, err)
+		}
+		if s.OperationMode != OpModeCool || s.SetFanSpeed != 2 || s.VaneVertical != VaneVertSwing || s.DemandPercentage != 80 || s.SetTemperature != 22 {
+			t.Errorf("Unmarshal(%s) = %+v", data, s)
+		}
+	}
+	for _, data := range []string{`{"Device":{"NumberOfFanSpeeds":5}}`, `{"Device":{"NumberOfFanSpeeds":5.0}}`} {
+		var d Device
+		if err := json.Unmarshal([]byte(data), &d); err != nil || d.NumberOfFanSpeeds != 5 {
+			t.Errorf("Unmarshal(%s): NumberOfFanSpeeds = %d, err = %v", data, d.NumberOfFanSpeeds, err)
+		}
+	}
+
+	var s AtaDeviceState
+	if err := json.Unmarshal([]byte(`{"SetFanSpeed":2.5}`), &s); err == nil {
+		t.Error("expected error for fractional fan speed")
+	}
+}
+
+func TestFirmwareUpdatePending(t *testing.T) {
+	tests := []struct {
+		json string
+		want bool
+	}{
+		{`{"DeviceID":1,"Device":{"FirmwareDeployment":null}}`, false},
+		{`{"DeviceID":1,"Device":{"FirmwareDeployment":{"Version":"33.00"}}}`, true},
+		{`{"DeviceID":1,"Device":{"FirmwareDeployment":{"Version":"33.00"},"FirmwareUpdateAborted":true}}`, false},
+	}
+	for _, tt := range tests {
+		var d Device
+		if err := json.Unmarshal([]byte(tt.json), &d); err != nil {
+			t.Fatalf("Unmarshal(%s) failed: %v", tt.json, err)
+		}
+		if got := d.FirmwareUpdatePending(); got != tt.want {
+			t.Errorf("FirmwareUpdatePending() for %s = %t, want %t", tt.json, got, tt.want)
+		}
+	}
+}
+
+func TestWritableControls(t *testing.T) {
+	var d Device
+	data := `{"DeviceID":1,"Device":{"ModelSupportsFanSpeed":true,"ModelSupportsVaneVertical":true,"ProhibitSetTemperature":true}}`
+	if err := json.Unmarshal([]byte(data), &d); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	want := Controls{Power: true, Mode: true, FanSpeed: true, VaneV: true}
+	if got := d.WritableControls(); got != want {
+		t.Errorf("WritableControls() = %+v, want %+v", got, want)
+	}
+
+	// Older responses without any ModelSupports* field offer every control
+	var old Device
+	if err := json.Unmarshal([]byte(`{"DeviceID":2,"Device":{"ProhibitPower":true}}`), &old); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	want = Controls{Mode: true, Temp: true, FanSpeed: true, VaneV: true, VaneH: true}
+	if got := old.WritableControls(); got != want {
+		t.Errorf("unreported capabilities: WritableControls() = %+v, want %+v", got, want)
+	}
+}
+
+func TestDeviceControlSchema(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"Structure":{"Devices":[{"DeviceID":1,"Device":{
+			"CanHeat":true,"CanCool":true,"ModelSupportsFanSpeed":true,"ModelSupportsVaneVertical":true,
+			"NumberOfFanSpeeds":3,"HasAutomaticFanSpeed":true,"MinTempHeat":10,"MaxTempHeat":31}}]}}]`))
+	})
+
+	schema, err := c.DeviceControlSchema(1)
+	if err != nil {
+		t.Fatalf("DeviceControlSchema failed: %v", err)
+	}
+	if got := strings.Join(schema.Modes, ","); got != "heat,cool,fan_only" {
+		t.Errorf("modes = %s", got)
+	}
+	if got := strings.Join(schema.FanSpeeds, ","); got != "auto,1,2,3" {
+		t.Errorf("fan speeds = %s", got)
+	}
+	if len(schema.VaneVertical) == 0 || len(schema.VaneHorizontal) != 0 {
+		t.Errorf("vanes: vertical %v, horizontal %v", schema.VaneVertical, schema.VaneHorizontal)
+	}
+	if r, ok := schema.Temperature[ModeHeat]; !ok || r.Min != 10 || r.Max != 31 || len(schema.Temperature) != 1 {
+		t.Errorf("temperature ranges = %v", schema.Temperature)
+	}
+
+	if _, err := c.DeviceControlSchema(99); err == nil {
+		t.Error("expected error for unknown device")
+	}
+
+	// Editing one schema must not affect later ones
+	schema.VaneVertical[0] = "edited"
+	if again, _ := c.DeviceControlSchema(1); again.VaneVertical[0] != VaneAuto {
+		t.Errorf("schema shares its vane options: %v", again.VaneVertical)
+	}
+}
+
+func TestNormalizeMAC(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"00:1A:2B:3C:4D:5E", "00:1a:2b:3c:4d:5e"},
+		{"00-1a-2b-3c-4d-5e", "00:1a:2b:3c:4d:5e"},
+		{"001A2B3C4D5E", "00:1a:2b:3c:4d:5e"},
+		{"001a.2b3c.4d5e", "00:1a:2b:3c:4d:5e"},
+		{" 00:1a:2b:3c:4d:5e ", "00:1a:2b:3c:4d:5e"},
+		{"", ""},
+		{"not-a-mac", "not-a-mac"},
+	}
+	for _, tt := range tests {
+		if got := NormalizeMAC(tt.in); got != tt.want {
+			t.Errorf("NormalizeMAC(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+
+	var d Device
+	if err := json.Unmarshal([]byte(`{"DeviceID":1,"MacAddress":"001A2B3C4D5E"}`), &d); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if d.MacAddress != "00:1a:2b:3c:4d:5e" {
+		t.Errorf("parsed MacAddress = %q, want canonical form", d.MacAddress)
+	}
+	idx := newDeviceIndex([]Device{d})
+	if got, ok := idx.FindDeviceByMAC("00-1A-2B-3C-4D-5E"); !ok || got.DeviceID != 1 {
+		t.Errorf("FindDeviceByMAC = %+v, %t; want device 1", got, ok)
+	}
+}
+
+func TestDeviceListCache(t *testing.T) {
+	var lists, gets int
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/User/ListDevices" {
+			lists++
+			w.Write([]byte(`[{"Structure":{"Devices":[{"DeviceID":1,"BuildingID":9}]}}]`))
+			return
+		}
+		gets++
+		w.Write([]byte(`{"DeviceID":1}`))
+	}, WithDeviceListCache(time.Minute))
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	c.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if devices, err := c.ListDevices(); err != nil || len(devices) != 1 {
+			t.Fatalf("ListDevices #%d = %+v, %v", i+1, devices, err)
+		}
+		if _, err := c.GetDeviceState(1, 9); err != nil {
+			t.Fatalf("GetDeviceState #%d failed: %v", i+1, err)
+		}
+	}
+	if lists != 1 || gets != 2 {
+		t.Errorf("within TTL: %d list and %d get requests, want 1 and 2", lists, gets)
+	}
+
+	now = now.Add(2 * time.Minute)
+	c.ListDevices()
+	c.InvalidateCache()
+	c.ListDevices()
+	if lists != 3 {
+		t.Errorf("after expiry and invalidation: %d list requests, want 3", lists)
+	}
+}
+
+func TestConditionalRequests(t *testing.T) {
+	var full, notModified int
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		full++
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte(`[{"Structure":{"Devices":[{"DeviceID":1},{"DeviceID":2}]}}]`))
+	}, WithConditionalRequests())
+
+	for i := 0; i < 2; i++ {
+		devices, err := c.ListDevices()
+		if err != nil {
+			t.Fatalf("ListDevices #%d failed: %v", i+1, err)
+		}
+		if len(devices) != 2 || devices[1].DeviceID != 2 {
+			t.Errorf("ListDevices #%d = %+v", i+1, devices)
+		}
+	}
+	if full != 1 || notModified != 1 {
+		t.Errorf("got %d full and %d not-modified responses, want 1 each", full, notModified)
+	}
+}
+
+func TestConditionalRequestsWithoutValidators(t *testing.T) {
+	var full int
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		full++
+		w.Write([]byte(`[{"Structure":{"Devices":[{"DeviceID":1}]}}]`))
+	}, WithConditionalRequests())
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	c.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if devices, err := c.ListDevices(); err != nil || len(devices) != 1 {
+			t.Fatalf("ListDevices #%d = %+v, %v", i+1, devices, err)
+		}
+	}
+	if full != 1 {
+		t.Errorf("server was asked %d times within the fallback TTL, want 1", full)
+	}
+
+	now = now.Add(conditionalFallbackTTL)
+	if _, err := c.ListDevices(); err != nil {
+		t.Fatalf("ListDevices after the TTL failed: %v", err)
+	}
+	if full != 2 {
+		t.Errorf("list not refetched after the fallback TTL")
+	}
+}
+
+func TestNotModifiedWithoutCachedList(t *testing.T) {
+	var requests int
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("Cache-Control") != "no-cache" {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write([]byte(`[{"Structure":{"Devices":[{"DeviceID":1}]}}]`))
+	}, WithConditionalRequests())
+
+	devices, err := c.ListDevices()
+	if err != nil || len(devices) != 1 || requests != 2 {
+		t.Errorf("ListDevices = %+v, %v after %d requests, want the full list after 2", devices, err, requests)
+	}
+
+	always := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotModified)
+	})
+	if _, err := always.ListDevices(); err == nil || !strings.Contains(err.Error(), "without a previous device list") {
+		t.Errorf("expected an explicit error for a 304 without a cached list, got %v", err)
+	}
+}
+
+func TestGetDeviceStateUnsupportedType(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"DeviceID":7,"DeviceType":1,"Power":true}`))
+	})
+
+	_, err := c.GetDeviceState(7, 1)
+	if !errors.Is(err, ErrUnsupportedDeviceType) {
+		t.Fatalf("expected ErrUnsupportedDeviceType, got %v", err)
+	}
+}
+
+func TestAccumulateDemand(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	samples := []DemandSample{
+		{Time: start, Demand: 50},
+		{Time: start.Add(time.Hour), Demand: 50},
+		{Time: start.Add(2 * time.Hour), Demand: 100},
+		{Time: start.Add(2 * time.Hour), Demand: 0}, // Duplicate timestamp contributes nothing
+	}
+
+	// 1h at 50% + 1h averaging 75%, on a 2 kW unit
+	if got, want := AccumulateDemand(samples, 2), 2.5; got != want {
+		t.Errorf("AccumulateDemand = %v, want %v", got, want)
+	}
+	if got := AccumulateDemand(samples[:1], 2); got != 0 {
+		t.Errorf("AccumulateDemand with one sample = %v, want 0", got)
+	}
+}
+
+func TestComfortStats(t *testing.T) {
+	samples := []AtaDeviceState{
+		{Power: true, OperationMode: OpModeHeat, SetTemperature: 21, RoomTemperature: 19},
+		{Power: true, OperationMode: OpModeHeat, SetTemperature: 21, RoomTemperature: 21.5},
+		{Power: true, OperationMode: OpModeHeat, SetTemperature: 21, RoomTemperature: 22},
+		{Power: false, RoomTemperature: 22},
+		{Power: true, OperationMode: OpModeCool, SetTemperature: 24, RoomTemperature: 23},
+	}
+
+	got := ComfortStats(samples, 10*time.Minute)
+	want := ComfortReport{
+		TimeAtSetpoint:   10 * time.Minute,
+		PoweredTime:      40 * time.Minute,
+		AverageDelta:     (2 + 0.5 + 1 + 1) / 4.0,
+		AverageOvershoot: (0.5 + 1 + 1) / 4.0,
+		Cycles:           1,
+	}
+	if got != want {
+		t.Errorf("ComfortStats = %+v, want %+v", got, want)
+	}
+	if got := ComfortStats(nil, time.Minute); got != (ComfortReport{}) {
+		t.Errorf("ComfortStats(nil) = %+v, want zero report", got)
+	}
+}
+
+func TestEstimateTimeToSetpoint(t *testing.T) {
+	start := time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC)
+	heating := []TempSample{
+		{start, 18},
+		{start.Add(10 * time.Minute), 18.5},
+		{start.Add(20 * time.Minute), 19},
+	}
+	if eta, ok := EstimateTimeToSetpoint(heating, 21); !ok || eta != 40*time.Minute {
+		t.Errorf("EstimateTimeToSetpoint() = %v, %t; want 40m", eta, ok)
+	}
+	if _, ok := EstimateTimeToSetpoint(heating, 17); ok {
+		t.Error("ETA reported for a trend moving away from the target")
+	}
+	if _, ok := EstimateTimeToSetpoint(heating[:1], 21); ok {
+		t.Error("ETA reported from a single sample")
+	}
+}
+
+func TestEnergyReportCOP(t *testing.T) {
+	var req map[string]interface{}
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/EnergyCost/Report" {
+			t.Errorf("unexpected request to %s", r.URL.Path)
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		w.Write([]byte(`{"TotalHeatingConsumed":10,"TotalHeatingProduced":35,
+			"HotWaterConsumed":[1,1],"HotWaterProduced":[2.5,2.5]}`))
+	})
+
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	report, err := c.GetEnergyReport(context.Background(), 7, from, from.AddDate(0, 1, 0))
+	if err != nil {
+		t.Fatalf("GetEnergyReport failed: %v", err)
+	}
+	if req["DeviceID"] != 7.0 || req["FromDate"] != "2024-01-01T00:00:00" || req["ToDate"] != "2024-02-01T00:00:00" {
+		t.Errorf("unexpected request body %v", req)
+	}
+	if cop, ok := report.COP(); !ok || cop != 40.0/12 || report.DeviceID != 7 {
+		t.Errorf("COP() = %v, %t for %+v", cop, ok, report)
+	}
+
+	ata := EnergyReport{TotalCoolingConsumed: 5}
+	if _, ok := ata.COP(); ok {
+		t.Error("COP reported for a report without produced energy")
+	}
+}
+
+func TestGetEnergyReports(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		var req struct{ DeviceID int }
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.DeviceID == 9 {
+			http.Error(w, "boom", http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]int{"DeviceID": req.DeviceID, "TotalCoolingConsumed": req.DeviceID})
+	})
+
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	reports, errs := c.GetEnergyReports(context.Background(), []int{7, 8, 9}, from, from.AddDate(0, 1, 0))
+	if len(reports) != 2 || reports[7].TotalCoolingConsumed != 7 || reports[8].TotalCoolingConsumed != 8 {
+		t.Errorf("unexpected reports %v", reports)
+	}
+	if len(errs) != 1 || errs[9] == nil {
+		t.Errorf("unexpected errors %v", errs)
+	}
+}
+
+func TestPoweredOffSetpoint(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			// Some units echo a power-off command without the setpoint
+			w.Write([]byte(`{"DeviceID":7,"DeviceType":0,"Power":false,"SetTemperature":0}`))
+			return
+		}
+		w.Write([]byte(`{"DeviceID":7,"DeviceType":0,"Power":false,"OperationMode":3,"SetTemperature":23.5}`))
+	})
+
+	state, err := c.GetDeviceState(7, 2)
+	if err != nil {
+		t.Fatalf("GetDeviceState failed: %v", err)
+	}
+	if state.SetTemperature != 23.5 || ToHAClimate(state).TargetTemperature != 23.5 {
+		t.Errorf("powered-off SetTemperature = %v, want 23.5", state.SetTemperature)
+	}
+
+	state.SetPower(false)
+	out, err := c.SetDeviceState(*state)
+	if err != nil {
+		t.Fatalf("SetDeviceState failed: %v", err)
+	}
+	if out.SetTemperature != 23.5 {
+		t.Errorf("SetTemperature after power off = %v, want 23.5", out.SetTemperature)
+	}
+}
+
+func TestSetDeviceStateResetsFlags(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"DeviceID":7,"DeviceType":0,"Power":true,"EffectiveFlags":1,"HasPendingCommand":true}`))
+	})
+
+	in := AtaDeviceState{DeviceID: 7, BuildingID: 2}
+	in.SetPower(true)
+
+	out, err := c.SetDeviceState(in)
+	if err != nil {
+		t.Fatalf("SetDeviceState failed: %v", err)
+	}
+	if out.EffectiveFlags != 0 || out.HasPendingCommand {
+		t.Errorf("returned state flags = %d, pending = %t; want both cleared", out.EffectiveFlags, out.HasPendingCommand)
+	}
+	if out.BuildingID != 2 {
+		t.Errorf("returned BuildingID = %d, want 2", out.BuildingID)
+	}
+	if in.EffectiveFlags != FlagPower || in.HasPendingCommand {
+		t.Errorf("input state was modified: flags = %d, pending = %t", in.EffectiveFlags, in.HasPendingCommand)
+	}
+}
+
+func TestTemperatureStepClasses(t *testing.T) {
+	var half, whole Device
+	if err := json.Unmarshal([]byte(`{"DeviceID":1,"DeviceName":"Hall","Device":{"TemperatureIncrement":0.5,"MinTempHeat":10}}`), &half); err != nil {
+		t.Fatalf("failed to decode device: %v", err)
+	}
+	if err := json.Unmarshal([]byte(`{"DeviceID":2,"Device":{"TemperatureIncrement":1}}`), &whole); err != nil {
+		t.Fatalf("failed to decode device: %v", err)
+	}
+	if half.DeviceName != "Hall" || half.MinTempHeat != 10 {
+		t.Errorf("nested configuration not merged: %+v", half)
+	}
+
+	// The nested object repeats some top-level fields; the top-level values win
+	var dup Device
+	data := `{"DeviceID":3,"DeviceName":"Hall","MacAddress":"AA-BB","Device":{"DeviceID":99,"DeviceName":"","MacAddress":"cc:dd","MinTempHeat":10}}`
+	if err := json.Unmarshal([]byte(data), &dup); err != nil {
+		t.Fatalf("failed to decode device: %v", err)
+	}
+	if dup.DeviceID != 3 || dup.DeviceName != "Hall" || dup.MacAddress != NormalizeMAC("AA-BB") || dup.MinTempHeat != 10 {
+		t.Errorf("nested fields overwrote top-level ones: %+v", dup)
+	}
+
+	if !half.SupportsHalfDegree() || whole.SupportsHalfDegree() {
+		t.Errorf("SupportsHalfDegree: half=%t whole=%t", half.SupportsHalfDegree(), whole.SupportsHalfDegree())
+	}
+	if got := half.RoundTemperature(21.3); got != 21.5 {
+		t.Errorf("half-degree RoundTemperature(21.3) = %v, want 21.5", got)
+	}
+	if got := whole.RoundTemperature(21.3); got != 21 {
+		t.Errorf("whole-degree RoundTemperature(21.3) = %v, want 21", got)
+	}
+	if got := whole.RoundTemperature(21.5); got != 22 {
+		t.Errorf("whole-degree RoundTemperature(21.5) = %v, want 22", got)
+	}
+}
+
+func TestSetDualSetpoints(t *testing.T) {
+	dual := &Device{DualSetpoint: true, MinTempAutomatic: 16, MaxTempAutomatic: 30}
+	var s AtaDeviceState
+	if err := s.SetDualSetpoints(20, 24, dual); err != nil {
+		t.Fatalf("SetDualSetpoints failed: %v", err)
+	}
+	if s.HeatSetTemperature != 20 || s.CoolSetTemperature != 24 || s.EffectiveFlags != FlagHeatSetTemp|FlagCoolSetTemp {
+		t.Errorf("unexpected state: %+v", s)
+	}
+	if err := s.SetDualSetpoints(15, 24, dual); err == nil {
+		t.Error("expected error for heating setpoint below the automatic range")
+	}
+	if err := s.SetDualSetpoints(25, 24, dual); err == nil {
+		t.Error("expected error for heating setpoint above cooling setpoint")
+	}
+
+	single := &Device{MinTempAutomatic: 16, MaxTempAutomatic: 30}
+	s = AtaDeviceState{OperationMode: OpModeHeatCool}
+	if err := s.SetDualSetpoints(20, 23, single); err != nil {
+		t.Fatalf("SetDualSetpoints failed: %v", err)
+	}
+	if s.SetTemperature != 21.5 || s.EffectiveFlags != FlagTargetTemp {
+		t.Errorf("single setpoint fallback: temp = %.1f, flags = %#x", s.SetTemperature, s.EffectiveFlags)
+	}
+
+	// Without device metadata both setpoints are written unchecked
+	s = AtaDeviceState{}
+	if err := s.SetDualSetpoints(12, 35, nil); err != nil {
+		t.Fatalf("SetDualSetpoints(nil) failed: %v", err)
+	}
+	if s.HeatSetTemperature != 12 || s.CoolSetTemperature != 35 || s.EffectiveFlags != FlagHeatSetTemp|FlagCoolSetTemp {
+		t.Errorf("nil device: unexpected state: %+v", s)
+	}
+	if err := s.SetDualSetpoints(25, 24, nil); err == nil {
+		t.Error("nil device: expected error for heating setpoint above cooling setpoint")
+	}
+}
+
+func TestActiveTempBounds(t *testing.T) {
+	dev := &Device{TemperatureIncrement: 1, MinTempHeat: 10, MaxTempHeat: 31, MinTempCoolDry: 16, MaxTempCoolDry: 31}
+	s := AtaDeviceState{OperationMode: OpModeDry, SetTemperature: 24}
+	if got, want := s.ActiveTempBounds(dev), (TempBounds{Min: 16, Max: 31, Step: 1, Current: 24}); got != want {
+		t.Errorf("ActiveTempBounds = %+v, want %+v", got, want)
+	}
+	s.OperationMode = OpModeFanOnly
+	if got := s.ActiveTempBounds(dev); got.Min != 0 || got.Max != 0 {
+		t.Errorf("fan-only bounds = %+v, want no range", got)
+	}
+	if got, want := s.ActiveTempBounds(nil), (TempBounds{Step: defaultTemperatureIncrement, Current: 24}); got != want {
+		t.Errorf("nil device bounds = %+v, want %+v", got, want)
+	}
+}
+
+func TestSetFanSpeedModeChecked(t *testing.T) {
+	dev := &Device{NumberOfFanSpeeds: 4, MinFanSpeed: 2, MaxFanSpeed: 5, HasAutomaticFanSpeed: true}
+	if min, max, auto := dev.FanSpeedBounds(); min != 2 || max != 5 || !auto {
+		t.Errorf("FanSpeedBounds = %d, %d, %t", min, max, auto)
+	}
+
+	var s AtaDeviceState
+	for _, speed := range []string{"auto", "2", "5"} {
+		if err := s.SetFanSpeedModeChecked(speed, dev); err != nil {
+			t.Errorf("speed %s: %v", speed, err)
+		}
+	}
+	for _, speed := range []string{"1", "6", "fast"} {
+		if err := s.SetFanSpeedModeChecked(speed, dev); err == nil {
+			t.Errorf("speed %s: expected error", speed)
+		}
+	}
+
+	var noAuto, unreported Device
+	if err := json.Unmarshal([]byte(`{"Device":{"NumberOfFanSpeeds":3,"HasAutomaticFanSpeed":false}}`), &noAuto); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetFanSpeedModeChecked(FanAuto, &noAuto); err == nil {
+		t.Error("expected error for auto on a unit without automatic fan speed")
+	}
+	if err := json.Unmarshal([]byte(`{"Device":{"NumberOfFanSpeeds":3}}`), &unreported); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetFanSpeedModeChecked(FanAuto, &unreported); err != nil {
+		t.Errorf("auto should be allowed when HasAutomaticFanSpeed is not reported: %v", err)
+	}
+	if err := s.SetFanSpeedModeChecked("7", &Device{}); err != nil {
+		t.Errorf("unknown capabilities should not restrict speed: %v", err)
+	}
+}
+
+func TestAtwSetZonePower(t *testing.T) {
+	s := AtwDeviceState{HasZone2: true, Zone1Power: true, Zone2Power: true, ForcedHotWaterMode: true}
+	if err := s.SetZonePower(2, false); err != nil {
+		t.Fatalf("disabling zone 2 failed: %v", err)
+	}
+	if s.Zone2Power || s.EffectiveFlags != FlagAtwZone2Power {
+		t.Errorf("unexpected state after disabling zone 2: %+v", s)
+	}
+	if err := s.SetZonePower(1, false); err == nil {
+		t.Error("expected error disabling the last zone during forced hot water")
+	}
+
+	s.ForcedHotWaterMode = false
+	if err := s.SetZonePower(1, false); err != nil {
+		t.Errorf("disabling zone 1 without hot water demand failed: %v", err)
+	}
+	if err := s.SetZonePower(3, true); err == nil {
+		t.Error("expected error for zone 3")
+	}
+	if err := (&AtwDeviceState{}).SetZonePower(2, true); err == nil {
+		t.Error("expected error for zone 2 on a single-zone system")
+	}
+}
+
+func TestGetAtwDeviceState(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/Device/Get" || r.URL.Query().Get("id") != "7" {
+			t.Errorf("unexpected request %s", r.URL)
+		}
+		w.Write([]byte(`{"DeviceID":7,"DeviceType":1,"Power":true,"HasZone2":true,"PowerZone1":true,
+			"SetTemperatureZone1":21.5,"TankWaterTemperature":48,"EffectiveFlags":0}`))
+	})
+
+	state, err := c.GetAtwDeviceState(7, 3)
+	if err != nil {
+		t.Fatalf("GetAtwDeviceState failed: %v", err)
+	}
+	if state.BuildingID != 3 || !state.Zone1Power || state.Zone2Power || state.SetTemperatureZone1 != 21.5 || state.TankWaterTemperature != 48 {
+		t.Errorf("unexpected state: %+v", state)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := c.GetAtwDeviceStateContext(ctx, 7, 3); !errors.Is(err, context.Canceled) {
+		t.Errorf("canceled context: err = %v", err)
+	}
+}
+
+func TestDefaultSetpoint(t *testing.T) {
+	s := AtaDeviceState{SetTemperature: 21, DefaultHeatingSetTemperature: 23, DefaultCoolingSetTemperature: 25}
+	tests := []struct {
+		mode int
+		want float64
+	}{
+		{OpModeHeat, 23},
+		{OpModeCool, 25},
+		{OpModeDry, 25},
+		{OpModeFanOnly, 21},
+		{OpModeHeatCool, 21},
+	}
+	for _, tt := range tests {
+		if got := s.DefaultSetpoint(tt.mode); got != tt.want {
+			t.Errorf("DefaultSetpoint(%d) = %.1f, want %.1f", tt.mode, got, tt.want)
+		}
+	}
+
+	// Units that report no defaults fall back to the current setpoint
+	noDefaults := AtaDeviceState{SetTemperature: 21}
+	if got := noDefaults.DefaultSetpoint(OpModeHeat); got != 21 {
+		t.Errorf("DefaultSetpoint without defaults = %.1f, want 21", got)
+	}
+}
+
+func TestFanOnlyTemperature(t *testing.T) {
+	s := AtaDeviceState{OperationMode: OpModeCool, SetTemperature: 22}
+	s.SetTargetTemperature(24)
+	if err := s.SetOperationMode(ModeFanOnly); err != nil {
+		t.Fatalf("SetOperationMode failed: %v", err)
+	}
+	if s.EffectiveFlags != FlagOperationMode {
+		t.Errorf("flags = %#x, want only FlagOperationMode", s.EffectiveFlags)
+	}
+	if s.TemperatureControllable() {
+		t.Error("TemperatureControllable should be false in fan-only mode")
+	}
+
+	s.SetTargetTemperature(20)
+	s.NudgeTemperature(1, &Device{})
+	if s.EffectiveFlags&FlagTargetTemp != 0 {
+		t.Error("temperature flag set in fan-only mode")
+	}
+}
+
+func TestFanOnlySetpointOrder(t *testing.T) {
+	// Setpoint first, then the mode: the setpoint held back in fan-only mode is sent
+	s := AtaDeviceState{OperationMode: OpModeFanOnly, SetTemperature: 20}
+	s.SetTargetTemperature(22)
+	if s.EffectiveFlags&FlagTargetTemp != 0 {
+		t.Error("temperature flag set in fan-only mode")
+	}
+	s.SetOperationMode(ModeCool)
+	if want := FlagOperationMode | FlagTargetTemp; s.EffectiveFlags != want || s.SetTemperature != 22 {
+		t.Errorf("setpoint then mode: flags = %#x, temp = %.1f", s.EffectiveFlags, s.SetTemperature)
+	}
+
+	// Mode first, then the setpoint
+	s = AtaDeviceState{OperationMode: OpModeFanOnly, SetTemperature: 20}
+	s.SetOperationMode(ModeCool)
+	s.SetTargetTemperature(22)
+	if want := FlagOperationMode | FlagTargetTemp; s.EffectiveFlags != want || s.SetTemperature != 22 {
+		t.Errorf("mode then setpoint: flags = %#x, temp = %.1f", s.EffectiveFlags, s.SetTemperature)
+	}
+
+	// Without a new setpoint, leaving fan-only mode only sends the mode
+	s = AtaDeviceState{OperationMode: OpModeFanOnly, SetTemperature: 20}
+	s.SetOperationMode(ModeHeat)
+	if s.EffectiveFlags != FlagOperationMode {
+		t.Errorf("mode only: flags = %#x", s.EffectiveFlags)
+	}
+}
+
+func TestHAClimateRoundTrip(t *testing.T) {
+	s := AtaDeviceState{Power: false, OperationMode: OpModeCool, SetTemperature: 22, VaneVertical: VaneVertSwing}
+	c := ToHAClimate(&s)
+	if c.HVACMode != HAModeOff || c.SwingMode != HASwingVertical || c.FanMode != FanAuto {
+		t.Errorf("unexpected HA state: %+v", c)
+	}
+
+	c.HVACMode = ModeHeat
+	c.SwingMode = HASwingHorizontal
+	if err := ApplyHAClimate(&s, c); err != nil {
+		t.Fatalf("ApplyHAClimate failed: %v", err)
+	}
+	if !s.Power || s.OperationMode != OpModeHeat || s.VaneVertical != VaneVertAuto || s.VaneHorizontal != VaneHorizSwing {
+		t.Errorf("unexpected state after apply: %+v", s)
+	}
+	if want := FlagPower | FlagOperationMode | FlagVaneVertical | FlagVaneHorizontal; s.EffectiveFlags != want {
+		t.Errorf("flags = %#x, want %#x", s.EffectiveFlags, want)
+	}
+
+	if err := ApplyHAClimate(&s, HAClimate{SwingMode: "diagonal"}); err == nil {
+		t.Error("expected error for invalid swing mode")
+	}
+
+	// Invalid values leave the state untouched, even if earlier fields were valid
+	s = AtaDeviceState{Power: false, OperationMode: OpModeCool, SetTemperature: 22}
+	before := s
+	if err := ApplyHAClimate(&s, HAClimate{HVACMode: "turbo"}); err == nil {
+		t.Error("expected error for invalid HVAC mode")
+	}
+	if err := ApplyHAClimate(&s, HAClimate{HVACMode: ModeHeat, TargetTemperature: 21, SwingMode: "diagonal"}); err == nil {
+		t.Error("expected error for invalid swing mode")
+	}
+	if !reflect.DeepEqual(s, before) {
+		t.Errorf("state changed after failed apply: %+v", s)
+	}
+}
+
+func TestHolidayModePeriod(t *testing.T) {
+	var s AtaDeviceState
+	if err := json.Unmarshal([]byte(`{"HolidayMode":true,"HolidayModeStartDate":"2024-02-24T08:00:00","HolidayModeEndDate":"2024-03-03T18:30:00"}`), &s); err != nil {
+		t.Fatalf("failed to decode state: %v", err)
+	}
+	start, end := s.HolidayModePeriod()
+	if !s.HolidayModeActive() || start != time.Date(2024, 2, 24, 8, 0, 0, 0, time.UTC) || end != time.Date(2024, 3, 3, 18, 30, 0, 0, time.UTC) {
+		t.Errorf("active = %t, period = %v - %v", s.HolidayModeActive(), start, end)
+	}
+
+	var unset AtaDeviceState
+	json.Unmarshal([]byte(`{"HolidayMode":false,"HolidayModeStartDate":null,"HolidayModeEndDate":null}`), &unset)
+	if start, end := unset.HolidayModePeriod(); unset.HolidayModeActive() || !start.IsZero() || !end.IsZero() {
+		t.Errorf("unset holiday mode: active = %t, period = %v - %v", unset.HolidayModeActive(), start, end)
+	}
+}
+
+func TestSummary(t *testing.T) {
+	s := AtaDeviceState{
+		DeviceName: "Living Room", Power: true, OperationMode: OpModeCool, SetTemperature: 22,
+		RoomTemperature: 24.5, VaneVertical: VaneVertSwing,
+	}
+	if got, want := s.Summary(), "Living Room: ON, cool 22.0°C (room 24.5°C), fan auto, vane V:swing H:auto"; got != want {
+		t.Errorf("Summary() = %q, want %q", got, want)
+	}
+
+	unknown := AtaDeviceState{DeviceID: 9, OperationMode: 42, VaneHorizontal: 99}
+	if got, want := unknown.Summary(), "Device 9: OFF, unknown 0.0°C (room 0.0°C), fan auto, vane V:auto H:unknown"; got != want {
+		t.Errorf("Summary() = %q, want %q", got, want)
+	}
+}
+
+func TestSetOperationModeChecked(t *testing.T) {
+	heatOnly := &Device{HeatSupported: true}
+	var s AtaDeviceState
+	err := s.SetOperationModeChecked(ModeCool, heatOnly)
+	if err == nil || !strings.Contains(err.Error(), "supported: heat, fan_only") || s.EffectiveFlags != 0 {
+		t.Errorf("cool on heating-only unit: err = %v, flags %#x", err, s.EffectiveFlags)
+	}
+	if err := s.SetOperationModeChecked(ModeHeat, heatOnly); err != nil || s.OperationMode != OpModeHeat {
+		t.Errorf("heat on heating-only unit: err = %v, mode %d", err, s.OperationMode)
+	}
+	if err := s.SetOperationModeChecked(ModeCool, &Device{}); err != nil {
+		t.Errorf("device without mode capabilities rejected cool: %v", err)
+	}
+	if err := s.SetOperationModeChecked(ModeDry, nil); err != nil || s.OperationMode != OpModeDry {
+		t.Errorf("nil device: err = %v, mode %d", err, s.OperationMode)
+	}
+	if err := s.SetFanSpeedModeChecked("3", nil); err != nil || s.SetFanSpeed != 3 {
+		t.Errorf("fan speed with nil device: err = %v, speed %d", err, s.SetFanSpeed)
+	}
+}
+
+func TestVaneState(t *testing.T) {
+	s := AtaDeviceState{VaneVertical: VaneVert3, VaneHorizontal: VaneHorizSwing}
+	if got, want := s.VaneState(), (VaneState{Vertical: "3", Horizontal: VaneSwing, Swinging: true}); got != want {
+		t.Errorf("VaneState() = %+v, want %+v", got, want)
+	}
+
+	if err := s.SetVaneState(VaneState{Vertical: VaneAuto, Horizontal: VaneSplit}); err != nil {
+		t.Fatalf("SetVaneState failed: %v", err)
+	}
+	if s.VaneVertical != VaneVertAuto || s.VaneHorizontal != VaneHorizSplit || s.EffectiveFlags != FlagVaneVertical|FlagVaneHorizontal {
+		t.Errorf("after SetVaneState: %+v, flags %#x", s.VaneState(), s.EffectiveFlags)
+	}
+	if err := s.SetVaneState(VaneState{Swinging: true}); err != nil || !s.VaneState().Swinging || s.VaneVertical != VaneVertSwing {
+		t.Errorf("SetVaneState(swinging): err = %v, state %+v", err, s.VaneState())
+	}
+}
+
+func TestSetChangeoverThreshold(t *testing.T) {
+	dev := &Device{MinTempAutomatic: 17, MaxTempAutomatic: 28}
+	var s AtaDeviceState
+	if err := s.SetChangeoverThreshold(21.3, dev); err != nil {
+		t.Fatalf("SetChangeoverThreshold failed: %v", err)
+	}
+	if s.ChangeoverTemperature != 21.5 || s.EffectiveFlags != FlagChangeover {
+		t.Errorf("got threshold %.1f, flags %#x", s.ChangeoverTemperature, s.EffectiveFlags)
+	}
+
+	s.ResetEffectiveFlags()
+	if err := s.SetChangeoverThreshold(30, dev); err == nil || s.EffectiveFlags != 0 {
+		t.Errorf("out-of-range threshold: err = %v, flags %#x", err, s.EffectiveFlags)
+	}
+
+	// Without device metadata only the default rounding applies
+	if err := s.SetChangeoverThreshold(30.2, nil); err != nil {
+		t.Fatalf("SetChangeoverThreshold(nil) failed: %v", err)
+	}
+	if s.ChangeoverTemperature != 30 || s.EffectiveFlags != FlagChangeover {
+		t.Errorf("nil device: got threshold %.1f, flags %#x", s.ChangeoverTemperature, s.EffectiveFlags)
+	}
+}
+
+func TestSetVanes(t *testing.T) {
+	var s AtaDeviceState
+	if err := s.SetVanes("3", VaneSplit); err != nil {
+		t.Fatalf("SetVanes failed: %v", err)
+	}
+	if s.VaneVertical != VaneVert3 || s.VaneHorizontal != VaneHorizSplit || s.EffectiveFlags != FlagVaneVertical|FlagVaneHorizontal {
+		t.Errorf("unexpected state: %+v", s)
+	}
+
+	s = AtaDeviceState{}
+	if err := s.SetVanes(VaneSwing, "sideways"); err == nil {
+		t.Error("expected error for invalid horizontal position")
+	}
+	if s.VaneVertical != 0 || s.EffectiveFlags != 0 {
+		t.Errorf("state modified on error: %+v", s)
+	}
+
+	if err := s.SetVaneVerticalInt(VaneVertSwing); err != nil || s.VaneVertical != VaneVertSwing {
+		t.Errorf("SetVaneVerticalInt: err = %v, vane = %d", err, s.VaneVertical)
+	}
+	if err := s.SetVaneHorizontalInt(6); err == nil {
+		t.Error("expected error for unknown horizontal position 6")
+	}
+}
+
+func TestEqualSettings(t *testing.T) {
+	a := AtaDeviceState{Power: true, OperationMode: OpModeCool, SetTemperature: 22, LastCommunication: "2024-01-01T10:00:00.000000"}
+	b := a
+	b.LastCommunication = "2024-01-01T10:05:00.000000"
+	b.EffectiveFlags = FlagPower
+	b.HasPendingCommand = true
+	b.RoomTemperature = 25
+
+	if !a.EqualSettings(&b) {
+		t.Error("states differing only in volatile fields should be equal")
+	}
+	b.SetTargetTemperature(23)
+	if a.EqualSettings(&b) {
+		t.Error("states with different setpoints should not be equal")
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/User/ListDevices":
+			w.Write([]byte(`[{"Structure":{"Devices":[{"DeviceID":1,"BuildingID":9},{"DeviceID":2,"BuildingID":9},{"DeviceID":3,"BuildingID":9}]}}]`))
+		case r.URL.Query().Get("id") == "2":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.Write([]byte(`{"DeviceID":` + r.URL.Query().Get("id") + `,"Power":true}`))
+		}
+	}, WithMaxConcurrency(2))
+
+	snapshots, err := c.Snapshot(context.Background())
+	if err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	if len(snapshots) != 3 {
+		t.Fatalf("got %d snapshots, want 3", len(snapshots))
+	}
+	for i, snap := range snapshots {
+		if snap.Device.DeviceID != i+1 {
+			t.Errorf("snapshot %d is for device %d", i, snap.Device.DeviceID)
+		}
+		if failed := snap.Device.DeviceID == 2; (snap.Err != nil) != failed {
+			t.Errorf("device %d: err = %v", snap.Device.DeviceID, snap.Err)
+		}
+		if snap.Err == nil && (snap.State == nil || snap.State.DeviceID != snap.Device.DeviceID || snap.State.BuildingID != 9) {
+			t.Errorf("device %d: unexpected state %+v", snap.Device.DeviceID, snap.State)
+		}
+	}
+}
+
+func TestReadOnlyAccess(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected request to %s", r.URL.Path)
+	})
+	c.rememberDevices([]Device{{DeviceID: 7, BuildingID: 1, AccessLevel: AccessLevelGuest}})
+
+	state := AtaDeviceState{DeviceID: 7}
+	state.SetPower(true)
+	if _, err := c.SetDeviceState(state); !errors.Is(err, ErrReadOnlyAccess) {
+		t.Errorf("SetDeviceState: err = %v, want ErrReadOnlyAccess", err)
+	}
+	if err := c.SetNightMode(7, 1, true); !errors.Is(err, ErrReadOnlyAccess) {
+		t.Errorf("SetNightMode: err = %v, want ErrReadOnlyAccess", err)
+	}
+	if d := (Device{AccessLevel: AccessLevelOwner}); !d.CanControl() {
+		t.Error("owner cannot control device")
+	}
+}
+
+func TestSetDeviceStateWithFlags(t *testing.T) {
+	var sent AtaDeviceState
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&sent)
+		json.NewEncoder(w).Encode(sent)
+	})
+
+	state := AtaDeviceState{DeviceID: 7, Power: true, EffectiveFlags: FlagTargetTemp}
+	if _, err := c.SetDeviceStateWithFlags(state, FlagPower|0x20); err != nil {
+		t.Fatalf("SetDeviceStateWithFlags failed: %v", err)
+	}
+	if sent.EffectiveFlags != FlagPower|0x20 || !sent.HasPendingCommand {
+		t.Errorf("sent flags %#x, pending %t", sent.EffectiveFlags, sent.HasPendingCommand)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := c.SetDeviceStateWithFlagsContext(ctx, state, FlagPower); !errors.Is(err, context.Canceled) {
+		t.Errorf("canceled context: err = %v", err)
+	}
+}
+
+func TestSetDeviceStateBackfillsBuildingID(t *testing.T) {
+	var sent AtaDeviceState
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&sent)
+		json.NewEncoder(w).Encode(sent)
+	})
+	c.rememberDevices([]Device{{DeviceID: 7, BuildingID: 42}})
+
+	state := AtaDeviceState{DeviceID: 7}
+	state.SetPower(true)
+	updated, err := c.SetDeviceState(state)
+	if err != nil {
+		t.Fatalf("SetDeviceState failed: %v", err)
+	}
+	if sent.BuildingID != 42 || updated.BuildingID != 42 {
+		t.Errorf("sent BuildingID %d, returned %d; want 42", sent.BuildingID, updated.BuildingID)
+	}
+}
+
+func TestZeroSetTemperature(t *testing.T) {
+	var s AtaDeviceState
+	if err := s.SetTargetTemperatureChecked(0, nil); !errors.Is(err, ErrZeroSetTemperature) || s.EffectiveFlags != 0 {
+		t.Errorf("SetTargetTemperatureChecked(0, nil): err = %v, flags %#x", err, s.EffectiveFlags)
+	}
+
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Error("zero setpoint was sent")
+	})
+	_, err := c.SetDeviceState(AtaDeviceState{DeviceID: 7, OperationMode: OpModeCool, EffectiveFlags: FlagTargetTemp})
+	if !errors.Is(err, ErrZeroSetTemperature) {
+		t.Errorf("SetDeviceState with zero setpoint: err = %v, want ErrZeroSetTemperature", err)
+	}
+
+	// A device whose range goes down to 0 accepts it in the setter, Validate and SetDeviceState
+	zeroDev := &Device{DeviceID: 8, MinTempHeat: 0, MaxTempHeat: 30}
+	heat := AtaDeviceState{DeviceID: 8, DeviceType: DeviceTypeAta, OperationMode: OpModeHeat, SetTemperature: 20}
+	if err := heat.SetTargetTemperatureChecked(0, zeroDev); err != nil || heat.EffectiveFlags != FlagTargetTemp {
+		t.Errorf("SetTargetTemperatureChecked(0) within range: err = %v, flags %#x", err, heat.EffectiveFlags)
+	}
+	if errs := heat.Validate(zeroDev); len(errs) != 0 {
+		t.Errorf("Validate with zero in range = %v", errs)
+	}
+	if errs := heat.Validate(nil); len(errs) != 1 || !errors.Is(errs[0], ErrZeroSetTemperature) {
+		t.Errorf("Validate without device = %v, want ErrZeroSetTemperature", errs)
+	}
+	normalDev := &Device{MinTempHeat: 10, MaxTempHeat: 30}
+	if err := (&AtaDeviceState{OperationMode: OpModeHeat}).SetTargetTemperatureChecked(0, normalDev); !errors.Is(err, ErrZeroSetTemperature) {
+		t.Errorf("SetTargetTemperatureChecked(0) outside range: err = %v", err)
+	}
+	if err := (&AtaDeviceState{OperationMode: OpModeHeat}).SetTargetTemperatureChecked(35, normalDev); err == nil {
+		t.Error("expected error for a setpoint above the range")
+	}
+
+	var sent int
+	c = newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		sent++
+		w.Write([]byte(`{"DeviceID":8,"DeviceType":0,"OperationMode":1,"SetTemperature":0}`))
+	})
+	c.rememberDevices([]Device{*zeroDev})
+	if _, err := c.SetDeviceState(heat); err != nil || sent != 1 {
+		t.Errorf("SetDeviceState with zero in range: err = %v, sent %d times", err, sent)
+	}
+}
+
+func TestDuplicateSuppression(t *testing.T) {
+	var sets int
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		sets++
+		body, _ := io.ReadAll(r.Body)
+		w.Write(body)
+	}, WithDuplicateSuppression(time.Minute))
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	c.now = func() time.Time { return now }
+
+	state := AtaDeviceState{DeviceID: 7, OperationMode: OpModeCool}
+	state.SetTargetTemperature(22)
+	if _, err := c.SetDeviceState(state); err != nil {
+		t.Fatalf("first SetDeviceState failed: %v", err)
+	}
+	if _, err := c.SetDeviceState(state); !errors.Is(err, ErrDuplicateCommand) {
+		t.Errorf("repeated command: err = %v, want ErrDuplicateCommand", err)
+	}
+
+	other := state
+	other.SetTargetTemperature(23)
+	if _, err := c.SetDeviceState(other); err != nil {
+		t.Errorf("different command was rejected: %v", err)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if _, err := c.SetDeviceState(other); err != nil {
+		t.Errorf("command after the window was rejected: %v", err)
+	}
+	if sets != 3 {
+		t.Errorf("server received %d commands, want 3", sets)
+	}
+}
+
+func TestDuplicateSuppressionRetryAfterFailedSend(t *testing.T) {
+	var sets int
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		sets++
+		body, _ := io.ReadAll(r.Body)
+		w.Write(body)
+	}, WithDuplicateSuppression(time.Minute))
+
+	state := AtaDeviceState{DeviceID: 7, OperationMode: OpModeCool}
+	state.SetTargetTemperature(22)
+
+	// The first attempt never reaches MELCloud
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := c.SetDeviceStateContext(ctx, state); err == nil {
+		t.Fatal("expected the cancelled send to fail")
+	}
+	if _, err := c.SetDeviceState(state); err != nil {
+		t.Errorf("retry after a failed send: %v", err)
+	}
+	if sets != 1 {
+		t.Errorf("server received %d commands, want 1", sets)
+	}
+
+	// Once the command went through, an identical one is suppressed
+	if _, err := c.SetDeviceState(state); !errors.Is(err, ErrDuplicateCommand) {
+		t.Errorf("repeated command: err = %v, want ErrDuplicateCommand", err)
+	}
+}
+
+func TestSetDeviceStateBatch(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost:
+			body, _ := io.ReadAll(r.Body)
+			w.Write(body)
+		case r.URL.Query().Get("id") == "2":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.Write([]byte(`{"DeviceID":` + r.URL.Query().Get("id") + `,"Power":true,"OperationMode":3}`))
+		}
+	}, WithMaxConcurrency(2))
+
+	off := false
+	results := c.SetDeviceStateBatch(context.Background(), []BatchUpdate{
+		{DeviceID: 1, BuildingID: 9, Update: StateUpdate{Power: &off}},
+		{DeviceID: 2, BuildingID: 9, Update: StateUpdate{Power: &off}},
+		{DeviceID: 3, BuildingID: 9, Update: StateUpdate{Power: &off}},
+	})
+	if len(results) != 3 {
+		t.Fatalf("got %d results, want 3", len(results))
+	}
+	for id, res := range results {
+		if failed := id == 2; (res.Err != nil) != failed {
+			t.Errorf("device %d: err = %v", id, res.Err)
+		}
+		if res.Err == nil && (res.State == nil || res.State.Power) {
+			t.Errorf("device %d: unexpected state %+v", id, res.State)
+		}
+	}
+}
+
+func TestLastCommunicationTime(t *testing.T) {
+	for _, value := range []string{"", "0001-01-01T00:00:00"} {
+		s := AtaDeviceState{LastCommunication: value}
+		if _, err := s.LastCommunicationTime(); !errors.Is(err, ErrNoCommunication) {
+			t.Errorf("LastCommunicationTime(%q): err = %v, want ErrNoCommunication", value, err)
+		}
+	}
+
+	s := AtaDeviceState{LastCommunication: "2024-01-02T15:04:05.123"}
+	got, err := s.LastCommunicationTime()
+	if want := time.Date(2024, 1, 2, 15, 4, 5, 123e6, time.UTC); err != nil || !got.Equal(want) {
+		t.Errorf("LastCommunicationTime() = %v, %v; want %v", got, err, want)
+	}
+}
+
+func TestGroupID(t *testing.T) {
+	var grouped, single Device
+	json.Unmarshal([]byte(`{"DeviceID":7,"Device":{"GroupID":12}}`), &grouped)
+	json.Unmarshal([]byte(`{"DeviceID":8,"GroupID":null}`), &single)
+	if id, ok := grouped.GroupID(); !ok || id != 12 {
+		t.Errorf("GroupID() = %d, %t; want 12", id, ok)
+	}
+	if _, ok := single.GroupID(); ok {
+		t.Error("GroupID reported for an ungrouped device")
+	}
+}
+
+func TestMaintenanceDates(t *testing.T) {
+	var d Device
+	err := json.Unmarshal([]byte(`{"DeviceID":7,"InstallationDate":"2021-04-12T00:00:00","RegistrationDate":"2021-04-13",
+		"LastServiceDate":null,"WarrantyEndDate":"2026-04-12T00:00:00"}`), &d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := d.InstalledAt(); !got.Equal(time.Date(2021, 4, 12, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("InstalledAt() = %v", got)
+	}
+	if got := d.RegisteredAt(); got.Day() != 13 {
+		t.Errorf("RegisteredAt() = %v", got)
+	}
+	if !d.LastServiceAt().IsZero() {
+		t.Errorf("LastServiceAt() = %v, want zero time", d.LastServiceAt())
+	}
+	if !d.UnderWarranty(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)) || d.UnderWarranty(time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Error("UnderWarranty gave unexpected results")
+	}
+}
+
+func TestSetVaneVerticalAngle(t *testing.T) {
+	var dev Device
+	err := json.Unmarshal([]byte(`{"DeviceID":7,"Device":{"VaneVerticalAngles":[{"Angle":15,"Value":21},{"Angle":30,"Value":22}]}}`), &dev)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var s AtaDeviceState
+	if err := s.SetVaneVerticalAngle(30, &dev); err != nil || s.VaneVertical != 22 || s.EffectiveFlags != FlagVaneVertical {
+		t.Errorf("SetVaneVerticalAngle(30) = %v, VaneVertical %d, flags %#x", err, s.VaneVertical, s.EffectiveFlags)
+	}
+	if deg, ok := s.VaneVerticalAngle(&dev); !ok || deg != 30 {
+		t.Errorf("VaneVerticalAngle() = %d, %t", deg, ok)
+	}
+	if errs := s.Validate(&Device{ModelSupportsVaneVertical: true, VaneVerticalAngles: dev.VaneVerticalAngles}); errs != nil {
+		t.Errorf("Validate() rejected a supported angle: %v", errs)
+	}
+	if err := s.SetVaneVerticalAngle(45, &dev); err == nil {
+		t.Error("SetVaneVerticalAngle accepted an unsupported angle")
+	}
+	if err := s.SetVaneVerticalAngle(30, &Device{}); err == nil {
+		t.Error("SetVaneVerticalAngle accepted a unit with presets only")
+	}
+	if err := s.SetVaneVerticalAngle(30, nil); err == nil {
+		t.Error("SetVaneVerticalAngle accepted a nil device")
+	}
+	if _, ok := s.VaneVerticalAngle(nil); ok {
+		t.Error("VaneVerticalAngle reported an angle for a nil device")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	dev := &Device{
+		MinTempCoolDry: 16, MaxTempCoolDry: 31,
+		NumberOfFanSpeeds: 3, HasAutomaticFanSpeed: true,
+		ModelSupportsFanSpeed: true, ModelSupportsVaneVertical: true,
+		CoolSupported: true, HeatSupported: true,
+		ProhibitPower: true,
+	}
+
+	s := AtaDeviceState{OperationMode: OpModeCool, SetTemperature: 22, VaneHorizontal: VaneHorizSwing}
+	s.EffectiveFlags = FlagOperationMode | FlagTargetTemp | FlagFanSpeed
+	if errs := s.Validate(dev); errs != nil {
+		t.Errorf("valid state reported %v", errs)
+	}
+
+	s.SetTemperature = 35
+	s.SetFanSpeed = 5
+	s.SetPower(true)
+	s.EffectiveFlags |= FlagVaneHorizontal
+	if errs := s.Validate(dev); len(errs) != 4 {
+		t.Errorf("Validate() = %v, want 4 problems (temperature, fan speed, power, vane)", errs)
+	}
+
+	dry := AtaDeviceState{OperationMode: OpModeDry, EffectiveFlags: FlagOperationMode}
+	if errs := dry.Validate(dev); len(errs) != 1 {
+		t.Errorf("Validate() for unsupported mode = %v, want 1 problem", errs)
+	}
+	if errs := dry.Validate(nil); errs != nil {
+		t.Errorf("Validate(nil) = %v, want no problems", errs)
+	}
+}
+
+func TestControllable(t *testing.T) {
+	pending, grid := false, false
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/User/ListDevices") {
+			w.Write([]byte(`[{"Structure":{"Devices":[{"DeviceID":7},{"DeviceID":8,"AccessLevel":3}]}}]`))
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"DeviceID": 7, "LastCommunication": "2024-01-02T15:04:05.000", "HasPendingCommand": pending,
+			"DemandSideControl": grid,
+		})
+	})
+	c.now = func() time.Time { return time.Date(2024, 1, 2, 15, 5, 0, 0, time.UTC) }
+
+	if ok, reason, err := c.Controllable(7, 2); !ok || err != nil {
+		t.Errorf("Controllable(7) = %t, %q, %v; want true", ok, reason, err)
+	}
+	grid = true
+	if ok, reason, _ := c.Controllable(7, 2); ok || !strings.Contains(reason, "demand-response") {
+		t.Errorf("Controllable(7) under grid control = %t, %q", ok, reason)
+	}
+	grid, pending = false, true
+	if ok, reason, _ := c.Controllable(7, 2); ok || !strings.Contains(reason, "not been applied") {
+		t.Errorf("Controllable(7) with pending command = %t, %q", ok, reason)
+	}
+	if ok, reason, _ := c.Controllable(8, 2); ok || !strings.Contains(reason, "view access") {
+		t.Errorf("Controllable(8) = %t, %q", ok, reason)
+	}
+	if ok, _, _ := c.Controllable(9, 2); ok {
+		t.Error("unknown device reported as controllable")
+	}
+
+	c.now = func() time.Time { return time.Date(2024, 1, 2, 18, 0, 0, 0, time.UTC) }
+	if ok, reason, _ := c.Controllable(7, 2); ok || reason != "device is offline" {
+		t.Errorf("Controllable(7) after 3h silence = %t, %q", ok, reason)
+	}
+}
+
+func TestTemperatureDelta(t *testing.T) {
+	s := AtaDeviceState{RoomTemperature: 20.5, SetTemperature: 22}
+	if d := s.TemperatureDelta(); d != -1.5 {
+		t.Errorf("TemperatureDelta() = %v, want -1.5", d)
+	}
+	if s.AtSetpoint(1) || !s.AtSetpoint(1.5) {
+		t.Errorf("AtSetpoint with delta %v gave unexpected results", s.TemperatureDelta())
+	}
+}
+
+func TestIsDefrosting(t *testing.T) {
+	for mode, want := range map[int]bool{0: false, 1: true, 2: true} {
+		s := AtaDeviceState{DefrostMode: mode}
+		if got := s.IsDefrosting(); got != want {
+			t.Errorf("IsDefrosting() with DefrostMode %d = %t, want %t", mode, got, want)
+		}
+	}
+
+	var s AtaDeviceState
+	if err := json.Unmarshal([]byte(`{"DefrostMode":1}`), &s); err != nil || !s.IsDefrosting() {
+		t.Errorf("DefrostMode not decoded: %v", err)
+	}
+}
+
+func TestMetricsAndTags(t *testing.T) {
+	s := AtaDeviceState{
+		DeviceID: 7, BuildingID: 3, MacAddress: "aa:bb", Power: true,
+		RoomTemperature: 21.5, SetTemperature: 22, OperationMode: OpModeCool, SetFanSpeed: 2,
+		DemandPercentage: 40, OutdoorTemperature: 8, WifiSignalStrength: -60, OperatingHours: 1200,
+		HasError: false, DefrostMode: 1, GridControl: true,
+	}
+	want := map[string]float64{
+		"power":                1,
+		"room_temperature":     21.5,
+		"set_temperature":      22,
+		"operation_mode":       OpModeCool,
+		"fan_speed":            2,
+		"demand_percentage":    40,
+		"outdoor_temperature":  8,
+		"wifi_signal_strength": -60,
+		"operating_hours":      1200,
+		"has_error":            0,
+		"defrosting":           1,
+		"grid_control":         1,
+	}
+	metrics := s.Metrics()
+	if len(metrics) != len(want) {
+		t.Errorf("Metrics() has %d keys, want %d: %v", len(metrics), len(want), metrics)
+	}
+	for key, v := range want {
+		if got, ok := metrics[key]; !ok || got != v {
+			t.Errorf("metric %s = %v (present: %t), want %v", key, got, ok, v)
+		}
+	}
+
+	off := AtaDeviceState{HasError: true}
+	if m := off.Metrics(); m["power"] != 0 || m["has_error"] != 1 || m["defrosting"] != 0 || m["grid_control"] != 0 {
+		t.Errorf("boolean metrics not encoded as 0/1: %v", m)
+	}
+
+	tags := s.Tags()
+	if len(tags) != 3 || tags["device_id"] != "7" || tags["building_id"] != "3" || tags["mac_address"] != "aa:bb" {
+		t.Errorf("Tags() = %v", tags)
+	}
+	if _, ok := tags["serial_number"]; ok {
+		t.Error("empty serial number reported as a tag")
+	}
+}
+
+func TestCompressorFrequency(t *testing.T) {
+	var s AtaDeviceState
+	if err := json.Unmarshal([]byte(`{"CompressorFrequency":42.0}`), &s); err != nil {
+		t.Fatal(err)
+	}
+	if hz, ok := s.CompressorFrequency(); !ok || hz != 42 || s.Metrics()["compressor_frequency"] != 42 {
+		t.Errorf("CompressorFrequency() = %d, %t", hz, ok)
+	}
+
+	var absent AtaDeviceState
+	json.Unmarshal([]byte(`{"Power":true}`), &absent)
+	if _, ok := absent.CompressorFrequency(); ok {
+		t.Error("CompressorFrequency reported for a unit without it")
+	}
+	if _, ok := absent.Metrics()["compressor_frequency"]; ok {
+		t.Error("compressor_frequency metric reported for a unit without it")
+	}
+}
+
+func TestGetDecoratedState(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/User/ListDevices") {
+			w.Write([]byte(`[{"Structure":{"Devices":[{"DeviceID":7,"Device":{"MinTempCoolDry":16,"MaxTempCoolDry":31,"ModelSupportsFanSpeed":true}}]}}]`))
+			return
+		}
+		w.Write([]byte(`{"DeviceID":7,"DeviceType":0,"Power":true,"OperationMode":3,"SetFanSpeed":0,
+			"VaneVertical":7,"HasError":true,"ErrorCode":5101,"LastCommunication":"2024-01-02T15:04:05.000"}`))
+	})
+	c.now = func() time.Time { return time.Date(2024, 1, 2, 15, 5, 0, 0, time.UTC) }
+
+	d, err := c.GetDecoratedState(context.Background(), 7, 2)
+	if err != nil {
+		t.Fatalf("GetDecoratedState failed: %v", err)
+	}
+	if d.Power != PowerOn || d.Mode != ModeCool || d.FanSpeed != FanAuto || d.VaneVertical != VaneSwing {
+		t.Errorf("unexpected strings %+v", d)
+	}
+	if d.TemperatureMin != 16 || d.TemperatureMax != 31 || !d.Controls.FanSpeed || !d.Controllable {
+		t.Errorf("unexpected capabilities %+v", d)
+	}
+	if len(d.Faults) != 1 || d.Faults[0].Code != 5101 {
+		t.Errorf("Faults = %+v", d.Faults)
+	}
+
+	// Controllable follows Client.Controllable: stale states and full lock-outs are not
+	now := time.Date(2024, 1, 2, 15, 5, 0, 0, time.UTC)
+	locked := &Device{ProhibitPower: true, ProhibitOperationMode: true, ProhibitSetTemperature: true}
+	if d.State.decorate(locked, now).Controllable {
+		t.Error("fully locked device reported as controllable")
+	}
+	if d.State.decorate(nil, now.Add(time.Hour)).Controllable {
+		t.Error("device silent for an hour reported as controllable")
+	}
+	if _, err := c.GetDecoratedState(context.Background(), 9, 2); err == nil || !strings.Contains(err.Error(), "not found on this account") {
+		t.Errorf("unknown device: err = %v", err)
+	}
+}
+
+func TestFaultText(t *testing.T) {
+	tests := []struct {
+		state AtaDeviceState
+		text  string
+		desc  string
+	}{
+		{AtaDeviceState{HasError: true, ErrorCode: 5101}, "Room temperature thermistor fault", "Room temperature thermistor fault"},
+		{AtaDeviceState{HasError: true, ErrorCode: 6840}, "Indoor/outdoor unit communication error", "Indoor/outdoor unit communication error"},
+		{AtaDeviceState{HasError: true, ErrorCode: 1234}, "Unknown error code 1234", "Unknown error code 1234"},
+		{AtaDeviceState{HasError: true, ErrorCode: ErrorCodeNone}, "No error", ""},
+		{AtaDeviceState{HasError: false, ErrorCode: 5101}, "Room temperature thermistor fault", ""},
+	}
+	for _, tt := range tests {
+		if got := FaultText(tt.state.ErrorCode); got != tt.text {
+			t.Errorf("FaultText(%d) = %q, want %q", tt.state.ErrorCode, got, tt.text)
+		}
+		if got := tt.state.FaultDescription(); got != tt.desc {
+			t.Errorf("FaultDescription() with HasError=%t, ErrorCode=%d = %q, want %q", tt.state.HasError, tt.state.ErrorCode, got, tt.desc)
+		}
+	}
+}
+
+func TestFaults(t *testing.T) {
+	var s AtaDeviceState
+	if err := json.Unmarshal([]byte(`{"HasError":true,"ErrorCode":5101,"ErrorCodes":[5101,8000,6840]}`), &s); err != nil {
+		t.Fatal(err)
+	}
+	faults := s.Faults()
+	if len(faults) != 2 || faults[0].Code != 5101 || faults[1].Code != 6840 || faults[1].Description != FaultText(6840) {
+		t.Errorf("Faults() = %+v", faults)
+	}
+
+	if faults := (&AtaDeviceState{ErrorCode: ErrorCodeNone}).Faults(); faults != nil {
+		t.Errorf("Faults() without error = %+v, want nil", faults)
+	}
+}
+
+func TestExportRestoreStates(t *testing.T) {
+	current := map[string]string{
+		"1": `{"DeviceID":1,"Power":true,"OperationMode":3,"SetTemperature":22}`,
+		"2": `{"DeviceID":2,"Power":false,"OperationMode":1,"SetTemperature":20}`,
+	}
+	var sets []AtaDeviceState
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/User/ListDevices":
+			w.Write([]byte(`[{"Structure":{"Devices":[{"DeviceID":1,"BuildingID":9},{"DeviceID":2,"BuildingID":9}]}}]`))
+		case r.Method == http.MethodPost:
+			var s AtaDeviceState
+			body, _ := io.ReadAll(r.Body)
+			json.Unmarshal(body, &s)
+			sets = append(sets, s)
+			w.Write(body)
+		default:
+			w.Write([]byte(current[r.URL.Query().Get("id")]))
+		}
+	}, WithMaxConcurrency(1))
+
+	data, err := c.ExportStates(context.Background())
+	if err != nil {
+		t.Fatalf("ExportStates failed: %v", err)
+	}
+
+	// Unchanged devices are not touched
+	if err := c.RestoreStates(context.Background(), data); err != nil || len(sets) != 0 {
+		t.Fatalf("RestoreStates of unchanged states: err = %v, %d sets", err, len(sets))
+	}
+
+	current["1"] = `{"DeviceID":1,"Power":false,"OperationMode":3,"SetTemperature":25}`
+	delete(current, "2")
+	data = []byte(strings.Replace(string(data), `"DeviceID": 2`, `"DeviceID": 3`, 1)) // Device 3 no longer exists
+	if err := c.RestoreStates(context.Background(), data); err != nil {
+		t.Fatalf("RestoreStates failed: %v", err)
+	}
+	if len(sets) != 1 {
+		t.Fatalf("got %d sets, want 1", len(sets))
+	}
+	if s := sets[0]; s.DeviceID != 1 || !s.Power || s.SetTemperature != 22 || s.EffectiveFlags != FlagPower|FlagTargetTemp {
+		t.Errorf("unexpected restore command %+v", s)
+	}
+}
+
+func TestRuntimeBetween(t *testing.T) {
+	a := &AtaDeviceState{OperatingHours: 1200}
+	b := &AtaDeviceState{OperatingHours: 1212.5}
+	if got, ok := RuntimeBetween(a, b); !ok || got != 12*time.Hour+30*time.Minute {
+		t.Errorf("RuntimeBetween = %v, %t; want 12h30m", got, ok)
+	}
+	if _, ok := RuntimeBetween(b, a); ok {
+		t.Error("RuntimeBetween accepted a counter that went backwards")
+	}
+	if _, ok := RuntimeBetween(&AtaDeviceState{}, b); ok {
+		t.Error("RuntimeBetween accepted a state without counter")
+	}
+}
+
+func TestConnectivity(t *testing.T) {
+	tests := []struct {
+		state AtaDeviceState
+		want  Connectivity
+	}{
+		{AtaDeviceState{WifiSignalStrength: -45}, Connectivity{RSSI: -45, Quality: SignalExcellent, Connected: true}},
+		{AtaDeviceState{WifiSignalStrength: -65}, Connectivity{RSSI: -65, Quality: SignalFair, Connected: true}},
+		{AtaDeviceState{WifiSignalStrength: -82, Offline: true}, Connectivity{RSSI: -82, Quality: SignalPoor}},
+		{AtaDeviceState{}, Connectivity{Quality: SignalUnknown, Connected: true}},
+	}
+	for _, tt := range tests {
+		if got := tt.state.Connectivity(); got != tt.want {
+			t.Errorf("Connectivity() = %+v, want %+v", got, tt.want)
+		}
+	}
+}
+
+func TestPowerOffAll(t *testing.T) {
+	var mu sync.Mutex
+	var switchedOff []int
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/User/ListDevices":
+			w.Write([]byte(`[{"Structure":{"Devices":[
+				{"DeviceID":1,"BuildingID":9},
+				{"DeviceID":2,"BuildingID":9},
+				{"DeviceID":3,"BuildingID":9,"AccessLevel":3},
+				{"DeviceID":4,"BuildingID":9,"DeviceType":1},
+				{"DeviceID":5,"BuildingID":9}]}}]`))
+		case r.Method == http.MethodPost:
+			var s AtaDeviceState
+			body, _ := io.ReadAll(r.Body)
+			json.Unmarshal(body, &s)
+			mu.Lock()
+			switchedOff = append(switchedOff, s.DeviceID)
+			mu.Unlock()
+			w.Write(body)
+		case r.URL.Query().Get("id") == "2":
+			w.Write([]byte(`{"DeviceID":2,"Power":false}`))
+		case r.URL.Query().Get("id") == "5":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.Write([]byte(`{"DeviceID":` + r.URL.Query().Get("id") + `,"Power":true}`))
+		}
+	})
+
+	results, err := c.PowerOffAll(context.Background())
+	if err != nil {
+		t.Fatalf("PowerOffAll failed: %v", err)
+	}
+	if len(results) != 2 || results[1] != nil || results[5] == nil {
+		t.Errorf("unexpected results %v", results)
+	}
+	if len(switchedOff) != 1 || switchedOff[0] != 1 {
+		t.Errorf("switched off %v, want only device 1", switchedOff)
+	}
+}
+
+func TestIsOffline(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		state AtaDeviceState
+		want  bool
+	}{
+		{"recent", AtaDeviceState{LastCommunication: "2024-01-01T11:59:00", LastSeen: "2024-01-01T11:59:30"}, false},
+		{"heartbeat only", AtaDeviceState{LastCommunication: "2024-01-01T10:00:00", LastSeen: "2024-01-01T11:59:30"}, true},
+		{"flagged", AtaDeviceState{LastCommunication: "2024-01-01T11:59:00", Offline: true}, true},
+		{"never", AtaDeviceState{}, true},
+	}
+	for _, tt := range tests {
+		if got := tt.state.IsOffline(now, 5*time.Minute); got != tt.want {
+			t.Errorf("%s: IsOffline = %t, want %t", tt.name, got, tt.want)
+		}
+	}
+
+	s := tests[1].state
+	if seen, err := s.LastSeenTime(); err != nil || !seen.Equal(now.Add(-30*time.Second)) {
+		t.Errorf("LastSeenTime() = %v, %v", seen, err)
+	}
+}
+
+func TestScheduleNextAction(t *testing.T) {
+	loc := time.FixedZone("CET", 3600)
+	schedule := &Schedule{Enabled: true, Entries: []ScheduleEntry{
+		{Day: time.Monday, Hour: 7, Minute: 0, Power: true, SetTemperature: 21},
+		{Day: time.Monday, Hour: 23, Minute: 0, Power: true, SetTemperature: 18},
+		{Day: time.Friday, Hour: 23, Minute: 0, Power: false},
+	}}
+
+	tests := []struct {
+		after time.Time
+		want  int
+		at    time.Time
+	}{
+		// Monday 2024-01-01 08:00 local: the evening entry is next
+		{time.Date(2024, 1, 1, 8, 0, 0, 0, loc), 1, time.Date(2024, 1, 1, 23, 0, 0, 0, loc)},
+		// Exactly at an entry's time: that entry has already fired
+		{time.Date(2024, 1, 1, 23, 0, 0, 0, loc), 2, time.Date(2024, 1, 5, 23, 0, 0, 0, loc)},
+		// Saturday: wraps around to Monday morning, input given in UTC
+		{time.Date(2024, 1, 6, 12, 0, 0, 0, time.UTC), 0, time.Date(2024, 1, 8, 7, 0, 0, 0, loc)},
+	}
+	for _, tt := range tests {
+		entry, at, ok := schedule.NextAction(tt.after, loc)
+		if !ok || entry != &schedule.Entries[tt.want] || !at.Equal(tt.at) {
+			t.Errorf("NextAction(%v) = %+v at %v, want entry %d at %v", tt.after, entry, at, tt.want, tt.at)
+		}
+	}
+
+	if _, _, ok := (&Schedule{Entries: schedule.Entries}).NextAction(time.Now(), loc); ok {
+		t.Error("disabled schedule returned an action")
+	}
+}
+
+func TestScheduleDSTWarnings(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("timezone data not available: %v", err)
+	}
+	s := Schedule{Enabled: true, Entries: []ScheduleEntry{
+		{Day: time.Sunday, Hour: 2, Minute: 30}, // Skipped in March, repeated in October
+		{Day: time.Sunday, Hour: 7},
+	}}
+
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, loc)
+	warnings := s.DSTWarnings(loc, from, from.AddDate(1, 0, 0))
+	if len(warnings) != 2 {
+		t.Fatalf("DSTWarnings() = %v, want 2 warnings", warnings)
+	}
+	if w := warnings[0]; w.Kind != DSTGap || w.Date.Format("2006-01-02") != "2024-03-31" || w.Entry != &s.Entries[0] {
+		t.Errorf("first warning = %v, want gap on 2024-03-31", w)
+	}
+	if w := warnings[1]; w.Kind != DSTOverlap || w.Date.Format("2006-01-02") != "2024-10-27" {
+		t.Errorf("second warning = %v, want overlap on 2024-10-27", w)
+	}
+}
+
+func TestDiffSnapshots(t *testing.T) {
+	snap := func(id int, state *AtaDeviceState) DeviceSnapshot {
+		return DeviceSnapshot{Device: Device{DeviceID: id}, State: state}
+	}
+	old := []DeviceSnapshot{
+		snap(1, &AtaDeviceState{Power: true, SetTemperature: 21, RoomTemperature: 20}),
+		snap(2, &AtaDeviceState{Power: true}),
+		snap(3, &AtaDeviceState{}),
+		snap(4, nil),
+	}
+	new := []DeviceSnapshot{
+		snap(1, &AtaDeviceState{Power: true, SetTemperature: 23, RoomTemperature: 20.5}),
+		snap(2, &AtaDeviceState{Power: true}),
+		snap(4, &AtaDeviceState{Power: true}),
+		snap(5, &AtaDeviceState{}),
+	}
+
+	diffs := DiffSnapshots(old, new)
+	if len(diffs) != 3 {
+		t.Fatalf("DiffSnapshots() = %+v, want 3 diffs", diffs)
+	}
+	want := FieldChange{Field: "SetTemperature", Old: 21.0, New: 23.0}
+	if d := diffs[0]; d.DeviceID != 1 || len(d.Changes) != 1 || d.Changes[0] != want {
+		t.Errorf("diff for device 1 = %+v, want only %+v", d, want)
+	}
+	if d := diffs[1]; d.DeviceID != 5 || !d.Added {
+		t.Errorf("diffs[1] = %+v, want device 5 added", d)
+	}
+	if d := diffs[2]; d.DeviceID != 3 || !d.Removed {
+		t.Errorf("diffs[2] = %+v, want device 3 removed", d)
+	}
+}
+
+func TestStuckCommands(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	snapshots := []DeviceSnapshot{
+		{Device: Device{DeviceID: 1}, State: &AtaDeviceState{HasPendingCommand: true, LastCommunication: "2024-01-01T11:00:00.000000"}},
+		{Device: Device{DeviceID: 2}, State: &AtaDeviceState{HasPendingCommand: true, LastCommunication: "2024-01-01T11:58:00.000000"}},
+		{Device: Device{DeviceID: 3}, State: &AtaDeviceState{LastCommunication: "2024-01-01T10:00:00.000000"}},
+		{Device: Device{DeviceID: 4}, Err: errors.New("fetch failed")},
+	}
+
+	stuck := StuckCommands(snapshots, 10*time.Minute, now)
+	if len(stuck) != 1 || stuck[0].Device.DeviceID != 1 {
+		t.Errorf("StuckCommands = %+v, want device 1 only", stuck)
+	}
+
+	// A unit that keeps communicating is stuck once the command it was sent is old enough
+	fresh := &AtaDeviceState{HasPendingCommand: true, LastCommunication: "2024-01-01T11:59:00.000000"}
+	snapshots = []DeviceSnapshot{
+		{Device: Device{DeviceID: 5}, State: fresh, CommandSentAt: now.Add(-time.Hour)},
+		{Device: Device{DeviceID: 6}, State: fresh, CommandSentAt: now.Add(-time.Minute)},
+	}
+	stuck = StuckCommands(snapshots, 10*time.Minute, now)
+	if len(stuck) != 1 || stuck[0].Device.DeviceID != 5 {
+		t.Errorf("StuckCommands = %+v, want device 5 only", stuck)
+	}
+}
+
+func TestPendingCommandsSendTime(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/User/ListDevices":
+			w.Write([]byte(`[{"Structure":{"Devices":[{"DeviceID":7,"BuildingID":1}]}}]`))
+		case "/Device/Get":
+			w.Write([]byte(`{"DeviceID":7,"HasPendingCommand":true,"LastCommunication":"2024-01-01T11:59:00.000000"}`))
+		default:
+			w.Write([]byte(`{"DeviceID":7,"Power":true}`))
+		}
+	})
+	now := time.Date(2024, 1, 1, 11, 0, 0, 0, time.UTC)
+	c.now = func() time.Time { return now }
+
+	state := AtaDeviceState{DeviceID: 7, BuildingID: 1}
+	state.SetPower(true)
+	if _, err := c.SetDeviceState(state); err != nil {
+		t.Fatalf("SetDeviceState failed: %v", err)
+	}
+
+	now = now.Add(time.Hour)
+	stuck, err := c.PendingCommands(context.Background(), 10*time.Minute)
+	if err != nil {
+		t.Fatalf("PendingCommands failed: %v", err)
+	}
+	if len(stuck) != 1 || !stuck[0].CommandSentAt.Equal(now.Add(-time.Hour)) {
+		t.Errorf("PendingCommands = %+v, want device 7 with the send time", stuck)
+	}
+}
+
+func TestMinRequestInterval(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[]`))
+	}, WithMinRequestInterval(50*time.Millisecond))
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if _, err := c.ListDevices(); err != nil {
+			t.Fatalf("ListDevices failed: %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("3 requests took %v, want at least 100ms", elapsed)
+	}
+}
+
+func TestSetDemandPercentage(t *testing.T) {
+	var sent AtaDeviceState
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			if err := json.NewDecoder(r.Body).Decode(&sent); err != nil {
+				t.Errorf("failed to decode request body: %v", err)
+			}
+			json.NewEncoder(w).Encode(sent)
+			return
+		}
+		w.Write([]byte(`{"DeviceID":7,"DeviceType":0,"DemandPercentage":45,"DemandLimit":100}`))
+	})
+
+	if err := c.SetDemandPercentage(7, 1, 60); err != nil {
+		t.Fatalf("SetDemandPercentage failed: %v", err)
+	}
+	if sent.DemandLimit != 60 || sent.EffectiveFlags != FlagDemandPercent {
+		t.Errorf("sent DemandLimit = %d, flags = %#x", sent.DemandLimit, sent.EffectiveFlags)
+	}
+	if sent.DemandPercentage != 45 {
+		t.Errorf("demand reading changed to %d, want it echoed as 45", sent.DemandPercentage)
+	}
+
+	if err := c.SetDemandPercentage(7, 1, 101); err == nil {
+		t.Error("expected error for demand percentage above 100")
+	}
+}
+
+func TestSetNightMode(t *testing.T) {
+	var sent AtaDeviceState
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			json.NewDecoder(r.Body).Decode(&sent)
+			json.NewEncoder(w).Encode(sent)
+			return
+		}
+		w.Write([]byte(`{"DeviceID":7,"DeviceType":0,"NightMode":false}`))
+	})
+
+	if err := c.SetNightMode(7, 1, true); err != nil {
+		t.Fatalf("SetNightMode failed: %v", err)
+	}
+	if !sent.NightMode || sent.EffectiveFlags != FlagNightMode {
+		t.Errorf("sent NightMode = %t, flags = %#x", sent.NightMode, sent.EffectiveFlags)
+	}
+}
+
+func TestServerTimeOffset(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Date", time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat))
+		w.Write([]byte(`[]`))
+	})
+
+	offset, err := c.ServerTimeOffset(context.Background())
+	if err != nil {
+		t.Fatalf("ServerTimeOffset failed: %v", err)
+	}
+	if offset > -59*time.Minute || offset < -61*time.Minute {
+		t.Errorf("offset = %v, want about -1h", offset)
+	}
+}
+
+func TestDo(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-MitsContextKey") != "test-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var in map[string]int
+		json.NewDecoder(r.Body).Decode(&in)
+		w.Write([]byte(`{"Path":"` + r.URL.Path + `","Echo":` + strconv.Itoa(in["Value"]) + `}`))
+	})
+
+	var out struct {
+		Path string
+		Echo int
+	}
+	if err := c.Do(context.Background(), http.MethodPost, "Beta/Feature", map[string]int{"Value": 42}, &out); err != nil {
+		t.Fatalf("Do failed: %v", err)
+	}
+	if out.Path != "/Beta/Feature" || out.Echo != 42 {
+		t.Errorf("unexpected response: %+v", out)
+	}
+	if err := c.Do(context.Background(), http.MethodGet, "/Beta/Feature", nil, nil); err != nil {
+		t.Errorf("Do without output failed: %v", err)
+	}
+}
+
+func TestSetDeviceStateRaw(t *testing.T) {
+	var got string
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		got = string(body)
+		if r.URL.Path != "/Device/SetAta" || r.Header.Get("X-MitsContextKey") != "test-token" {
+			t.Errorf("unexpected request to %s", r.URL.Path)
+		}
+		w.Write([]byte(`{"DeviceID":7,"DeviceType":0,"Power":true,"EffectiveFlags":1}`))
+	})
+
+	payload := `{"DeviceID":7,"Power":true,"EffectiveFlags":1,"Undocumented":42}`
+	out, err := c.SetDeviceStateRaw(context.Background(), json.RawMessage(payload))
+	if err != nil {
+		t.Fatalf("SetDeviceStateRaw failed: %v", err)
+	}
+	if got != payload {
+		t.Errorf("sent %s, want the payload unchanged", got)
+	}
+	if !out.Power || out.EffectiveFlags != FlagPower {
+		t.Errorf("unexpected response %+v", out)
+	}
+	if _, err := c.SetDeviceStateRaw(context.Background(), json.RawMessage(`{`)); err == nil {
+		t.Error("SetDeviceStateRaw accepted invalid JSON")
+	}
+}
+
+func TestSetDeviceStateIfUnchanged(t *testing.T) {
+	current := `{"DeviceID":7,"DeviceType":0,"Power":true,"OperationMode":3,"SetTemperature":21}`
+	var posts int
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			posts++
+			var s AtaDeviceState
+			json.NewDecoder(r.Body).Decode(&s)
+			json.NewEncoder(w).Encode(s)
+			return
+		}
+		w.Write([]byte(current))
+	})
+
+	baseline, err := c.GetDeviceState(7, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := *baseline
+	state.SetPower(false)
+	if _, err := c.SetDeviceStateIfUnchanged(context.Background(), baseline, state); err != nil || posts != 1 {
+		t.Fatalf("unchanged device: err = %v, %d sets", err, posts)
+	}
+
+	// Someone raises the setpoint on the remote in the meantime
+	current = `{"DeviceID":7,"DeviceType":0,"Power":true,"OperationMode":3,"SetTemperature":24}`
+	_, err = c.SetDeviceStateIfUnchanged(context.Background(), baseline, state)
+	var conflict *ConflictError
+	if !errors.As(err, &conflict) || posts != 1 {
+		t.Fatalf("err = %v with %d sets, want a ConflictError and no set", err, posts)
+	}
+	want := FieldChange{Field: "SetTemperature", Old: 21.0, New: 24.0}
+	if len(conflict.Changes) != 1 || conflict.Changes[0] != want || conflict.Current.SetTemperature != 24 {
+		t.Errorf("conflict = %+v, want only %+v", conflict, want)
+	}
+}
+
+func TestSetDeviceStateTypeMismatch(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			t.Error("SetAta should not be called for a mismatched device type")
+		}
+	})
+	c.rememberDevices([]Device{{DeviceID: 7, DeviceType: DeviceTypeAtw}})
+
+	state := AtaDeviceState{DeviceID: 7, DeviceType: DeviceTypeAta}
+	state.SetPower(false)
+	if _, err := c.SetDeviceState(state); !errors.Is(err, ErrDeviceTypeMismatch) {
+		t.Errorf("expected ErrDeviceTypeMismatch, got %v", err)
+	}
+}
+
+func TestAutoRound(t *testing.T) {
+	var sent AtaDeviceState
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&sent)
+		json.NewEncoder(w).Encode(sent)
+	}, WithAutoRound())
+	c.rememberDevices([]Device{{DeviceID: 7, TemperatureIncrement: 1, MinTempCoolDry: 16, MaxTempCoolDry: 31}})
+
+	for _, tt := range []struct{ in, want float64 }{{22.4, 22}, {12, 16}, {35.5, 31}} {
+		state := AtaDeviceState{DeviceID: 7, OperationMode: OpModeCool}
+		state.SetTargetTemperature(tt.in)
+		if _, err := c.SetDeviceState(state); err != nil {
+			t.Fatalf("SetDeviceState failed: %v", err)
+		}
+		if sent.SetTemperature != tt.want {
+			t.Errorf("sent %.1f for %.1f, want %.1f", sent.SetTemperature, tt.in, tt.want)
+		}
+	}
+}
+
+func TestSetDeviceStatePartialApply(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		// The unit powers on but keeps its old mode and setpoint
+		w.Write([]byte(`{"DeviceID":7,"DeviceType":0,"Power":true,"OperationMode":1,"SetTemperature":20}`))
+	})
+
+	in := AtaDeviceState{DeviceID: 7, OperationMode: OpModeHeat, SetTemperature: 20}
+	in.SetPower(true)
+	in.SetOperationMode(ModeCool)
+	in.SetTargetTemperature(22)
+
+	out, err := c.SetDeviceState(in)
+	var partial *PartialApplyError
+	if !errors.As(err, &partial) {
+		t.Fatalf("expected PartialApplyError, got %v", err)
+	}
+	if got := strings.Join(partial.Fields, ","); got != "OperationMode,SetTemperature" {
+		t.Errorf("unapplied fields = %s", got)
+	}
+	if out == nil || !out.Power {
+		t.Errorf("expected the echoed state to be returned, got %+v", out)
+	}
+	if partial.Transitioning() {
+		t.Error("unit without transient flags reported as transitioning")
+	}
+}
+
+func TestRollbackOnPartialApply(t *testing.T) {
+	var sets []AtaDeviceState
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Write([]byte(`{"DeviceID":7,"DeviceType":0,"Power":false,"OperationMode":1,"SetTemperature":20}`))
+			return
+		}
+		var s AtaDeviceState
+		json.NewDecoder(r.Body).Decode(&s)
+		sets = append(sets, s)
+		s.OperationMode = OpModeHeat // The unit refuses to change mode
+		json.NewEncoder(w).Encode(s)
+	}, WithRollbackOnPartialApply())
+
+	in := AtaDeviceState{DeviceID: 7, OperationMode: OpModeHeat, SetTemperature: 20}
+	in.SetPower(true)
+	in.SetOperationMode(ModeCool)
+
+	out, err := c.SetDeviceState(in)
+	var partial *PartialApplyError
+	if !errors.As(err, &partial) || !partial.RolledBack || partial.RollbackErr != nil {
+		t.Fatalf("expected rolled back PartialApplyError, got %v", err)
+	}
+	if len(sets) != 2 || sets[1].Power || sets[1].EffectiveFlags != FlagPower {
+		t.Fatalf("unexpected commands %+v", sets)
+	}
+	if out == nil || out.Power {
+		t.Errorf("returned state %+v, want the state after rollback", out)
+	}
+	if !strings.Contains(err.Error(), "rolled back") {
+		t.Errorf("error does not mention the rollback: %v", err)
+	}
+}
+
+func TestPartialApplyTransitioning(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"DeviceID":7,"DeviceType":0,"OperationMode":1,"InModeChange":true}`))
+	})
+
+	in := AtaDeviceState{DeviceID: 7, OperationMode: OpModeHeat}
+	in.SetOperationMode(ModeCool)
+	_, err := c.SetDeviceState(in)
+	var partial *PartialApplyError
+	if !errors.As(err, &partial) || !partial.Transitioning() {
+		t.Fatalf("expected transitioning PartialApplyError, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "changing mode") {
+		t.Errorf("error does not mention the mode change: %v", err)
+	}
+}
+
+func TestPowerState(t *testing.T) {
+	tests := []struct {
+		data          string
+		power         string
+		transitioning bool
+	}{
+		{`{"Power":true}`, PowerOn, false},
+		{`{"Power":false}`, PowerOff, false},
+		{`{"Power":true,"InStandbyMode":true}`, PowerStandby, true},
+		{`{"Power":false,"InStandbyMode":true}`, PowerOff, true},
+		{`{"Power":true,"InModeChange":true}`, PowerOn, true},
+	}
+	for _, tt := range tests {
+		var s AtaDeviceState
+		if err := json.Unmarshal([]byte(tt.data), &s); err != nil {
+			t.Fatalf("failed to decode %s: %v", tt.data, err)
+		}
+		if got := s.PowerState(); got != tt.power {
+			t.Errorf("PowerState() of %s = %s, want %s", tt.data, got, tt.power)
+		}
+		if got := s.Transitioning(); got != tt.transitioning {
+			t.Errorf("Transitioning() of %s = %t, want %t", tt.data, got, tt.transitioning)
+		}
+	}
+
+	// The read-only flags must decode from MELCloud's field names
+	var s AtaDeviceState
+	if err := json.Unmarshal([]byte(`{"InStandbyMode":true,"InModeChange":true}`), &s); err != nil || !s.StandbyMode || !s.ModeChanging {
+		t.Errorf("standby/mode change flags not decoded: %+v, %v", s, err)
+	}
+}
+
+func TestPartialApplyWiredControllerHint(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"DeviceID":7,"DeviceType":0,"OperationMode":1}`))
+	})
+	c.rememberDevices([]Device{{DeviceID: 7, DeviceName: "Hall", WiredController: true}})
+
+	in := AtaDeviceState{DeviceID: 7, OperationMode: OpModeHeat}
+	in.SetOperationMode(ModeCool)
+	_, err := c.SetDeviceState(in)
+	var partial *PartialApplyError
+	if !errors.As(err, &partial) {
+		t.Fatalf("expected PartialApplyError, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "wired remote controller") {
+		t.Errorf("error does not mention the wired controller: %v", err)
+	}
+}
+
+func TestSetDeviceStateOmitsDeviceName(t *testing.T) {
+	var body []byte
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+		w.Write([]byte(`{"DeviceID":7,"DeviceType":0,"Power":true}`))
+	})
+
+	in := AtaDeviceState{DeviceID: 7, DeviceName: "Hall"}
+	in.SetPower(true)
+	out, err := c.SetDeviceState(in)
+	if err != nil {
+		t.Fatalf("SetDeviceState failed: %v", err)
+	}
+	if strings.Contains(string(body), "DeviceName") {
+		t.Errorf("payload includes DeviceName: %s", body)
+	}
+	if out.DeviceName != "Hall" {
+		t.Errorf("DeviceName = %q, want it kept on the returned state", out.DeviceName)
+	}
+}
+
+func TestBuildIndex(t *testing.T) {
+	var lists int
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/User/ListDevices" {
+			lists++
+			w.Write([]byte(`[{"Structure":{"Devices":[
+				{"DeviceID":1,"BuildingID":5,"DeviceName":"Bedroom","MacAddress":"aa:bb"},
+				{"DeviceID":2,"BuildingID":6,"DeviceName":"Office","MacAddress":"cc:dd"}]}}]`))
+			return
+		}
+		if r.URL.Query().Get("buildingID") != "6" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{"DeviceID":2,"DeviceType":0}`))
+	})
+
+	idx, err := c.BuildIndex()
+	if err != nil {
+		t.Fatalf("BuildIndex failed: %v", err)
+	}
+	if idx.ByID[2].DeviceName != "Office" || idx.ByName["Bedroom"].DeviceID != 1 || idx.ByMAC["cc:dd"].DeviceID != 2 {
+		t.Errorf("unexpected index: %+v", idx)
+	}
+
+	state, err := c.GetDeviceStateByID(2)
+	if err != nil {
+		t.Fatalf("GetDeviceStateByID failed: %v", err)
+	}
+	if state.BuildingID != 6 {
+		t.Errorf("BuildingID = %d, want 6", state.BuildingID)
+	}
+	if lists != 1 {
+		t.Errorf("devices listed %d times, want 1", lists)
+	}
+}
+
+func TestConnectionPoolOptions(t *testing.T) {
+	c := newClient(WithMaxIdleConns(8), WithIdleConnTimeout(time.Minute))
+	tr, ok := c.httpClient.Transport.(*http.Transport)
+	if !ok {
+		t.Fatalf("transport is %T, want *http.Transport", c.httpClient.Transport)
+	}
+	if tr.MaxIdleConns != 8 || tr.MaxIdleConnsPerHost != 8 || tr.IdleConnTimeout != time.Minute {
+		t.Errorf("transport not tuned: MaxIdleConns=%d PerHost=%d IdleConnTimeout=%v", tr.MaxIdleConns, tr.MaxIdleConnsPerHost, tr.IdleConnTimeout)
+	}
+
+	// By default, as many connections stay idle as forEach runs requests in parallel
+	c = newClient(WithMaxConcurrency(12))
+	if tr := c.httpClient.Transport.(*http.Transport); tr.MaxIdleConnsPerHost != 12 {
+		t.Errorf("MaxIdleConnsPerHost = %d, want the concurrency limit 12", tr.MaxIdleConnsPerHost)
+	}
+
+	custom := &http.Transport{}
+	if c := newClient(WithTransport(custom), WithMaxIdleConns(8)); c.httpClient.Transport != custom {
+		t.Error("WithTransport was not used")
+	}
+}
+
+func TestJitter(t *testing.T) {
+	if d := newClient().jitter(); d != 0 {
+		t.Errorf("jitter without WithPollJitter = %v, want 0", d)
+	}
+	c := newClient(WithPollJitter(50 * time.Millisecond))
+	for i := 0; i < 100; i++ {
+		if d := c.jitter(); d < 0 || d >= 50*time.Millisecond {
+			t.Fatalf("jitter = %v, want in [0, 50ms)", d)
+		}
+	}
+}
+
+func TestStableID(t *testing.T) {
+	tests := []struct {
+		dev  Device
+		want string
+	}{
+		{Device{DeviceID: 7, MacAddress: "00:1a:2b:3c:4d:5e", SerialNumber: "2201234"}, "2201234"},
+		{Device{DeviceID: 7, MacAddress: "00:1a:2b:3c:4d:5e"}, "00:1a:2b:3c:4d:5e"},
+		{Device{DeviceID: 7, MacAddress: "00-1A-2B-3C-4D-5E"}, "00:1a:2b:3c:4d:5e"},
+		{Device{DeviceID: 7, MacAddress: "001a.2b3c.4d5e"}, "00:1a:2b:3c:4d:5e"},
+		{Device{DeviceID: 7}, "7"},
+	}
+	for _, tt := range tests {
+		if got := tt.dev.StableID(); got != tt.want {
+			t.Errorf("StableID() of %+v = %q, want %q", tt.dev, got, tt.want)
+		}
+	}
+}
+
+func TestRateBudget(t *testing.T) {
+	var throttle bool
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if throttle {
+			w.Header().Set("Retry-After", "30")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte(`[]`))
+	})
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	c.now = func() time.Time { return now }
+
+	if remaining, _ := c.RateBudget(); remaining != -1 {
+		t.Errorf("without limits: remaining = %d, want -1", remaining)
+	}
+
+	c.ListDevices()
+	c.ListDevices()
+	c.minRequestInterval = 10 * time.Second // 6 requests per minute; set after sending so the test doesn't wait
+	now = now.Add(20 * time.Second)
+	if remaining, resetIn := c.RateBudget(); remaining != 4 || resetIn != 40*time.Second {
+		t.Errorf("after 2 requests: RateBudget() = %d, %v; want 4, 40s", remaining, resetIn)
+	}
+
+	throttle = true
+	c.minRequestInterval = 0
+	c.ListDevices()
+	if remaining, resetIn := c.RateBudget(); remaining != 0 || resetIn != 30*time.Second {
+		t.Errorf("after 429: RateBudget() = %d, %v; want 0, 30s", remaining, resetIn)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	var calls int
+	healthy := false
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if !healthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`[]`))
+	}, WithCircuitBreaker(2, time.Minute))
+
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	c.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if _, err := c.ListDevices(); err == nil || errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("request %d: expected server error, got %v", i, err)
+		}
+	}
+	if _, err := c.ListDevices(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected ErrCircuitOpen after 2 failures, got %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("server called %d times, want 2", calls)
+	}
+
+	// After the cooldown a failing probe reopens the breaker
+	now = now.Add(time.Minute)
+	if _, err := c.ListDevices(); err == nil || errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected probe request to reach the server, got %v", err)
+	}
+	if _, err := c.ListDevices(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected breaker to reopen, got %v", err)
+	}
+
+	// A successful probe closes it
+	now = now.Add(time.Minute)
+	healthy = true
+	for i := 0; i < 3; i++ {
+		if _, err := c.ListDevices(); err != nil {
+			t.Fatalf("request after recovery failed: %v", err)
+		}
+	}
+}
+
+func TestWaitFor(t *testing.T) {
+	var reads int
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		reads++
+		w.Write([]byte(`{"DeviceID":7,"RoomTemperature":` + strconv.Itoa(19+reads) + `}`))
+	})
+
+	state, err := c.WaitFor(context.Background(), 7, 1, time.Millisecond, func(s *AtaDeviceState) bool {
+		return s.RoomTemperature >= 22
+	})
+	if err != nil {
+		t.Fatalf("WaitFor failed: %v", err)
+	}
+	if state.RoomTemperature != 22 || reads != 3 {
+		t.Errorf("room temperature = %.1f after %d reads, want 22 after 3", state.RoomTemperature, reads)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	state, err = c.WaitFor(ctx, 7, 1, 5*time.Millisecond, func(*AtaDeviceState) bool { return false })
+	if !errors.Is(err, context.DeadlineExceeded) || state == nil {
+		t.Errorf("expected deadline exceeded with last state, got %v, %v", state, err)
+	}
+
+	reads = 0
+	if _, err := c.WaitFor(context.Background(), 7, 1, 0, func(*AtaDeviceState) bool { return false }); err == nil || reads != 0 {
+		t.Errorf("expected error without polling for a zero interval, got %v after %d reads", err, reads)
+	}
+}
+
+func TestDevicePresets(t *testing.T) {
+	var sent AtaDeviceState
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/Device/ListPresets":
+			if r.URL.Query().Get("id") != "7" {
+				t.Errorf("unexpected query %s", r.URL.RawQuery)
+			}
+			w.Write([]byte(`[{"ID":1,"NumberDescription":"Night","Power":true,"OperationMode":3,
+				"SetTemperature":24,"FanSpeed":1,"VaneVertical":5,"VaneHorizontal":3}]`))
+		case r.Method == http.MethodPost:
+			json.NewDecoder(r.Body).Decode(&sent)
+			json.NewEncoder(w).Encode(sent)
+		default:
+			w.Write([]byte(`{"DeviceID":7,"DeviceType":0,"OperationMode":1,"SetTemperature":20}`))
+		}
+	})
+
+	presets, err := c.ListDevicePresets(7)
+	if err != nil || len(presets) != 1 || presets[0].Name != "Night" {
+		t.Fatalf("ListDevicePresets() = %+v, %v", presets, err)
+	}
+	if err := c.ApplyDevicePreset(7, 2, 1); err != nil {
+		t.Fatalf("ApplyDevicePreset failed: %v", err)
+	}
+	if !sent.Power || sent.OperationMode != OpModeCool || sent.SetTemperature != 24 || sent.SetFanSpeed != 1 || sent.VaneVertical != 5 {
+		t.Errorf("unexpected command %+v", sent)
+	}
+	if err := c.ApplyDevicePreset(7, 2, 9); err == nil {
+		t.Error("ApplyDevicePreset accepted an unknown preset")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := c.ApplyDevicePresetContext(ctx, 7, 2, 1); !errors.Is(err, context.Canceled) {
+		t.Errorf("ApplyDevicePresetContext with a cancelled context: err = %v", err)
+	}
+}
+
+func TestScenes(t *testing.T) {
+	var triggered int
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/Scene/GetScenes":
+			w.Write([]byte(`[{"SceneID":3,"SceneName":"Night","BuildingID":1,"Enabled":true}]`))
+		case "/Scene/Activate":
+			var body struct{ SceneID int }
+			json.NewDecoder(r.Body).Decode(&body)
+			triggered = body.SceneID
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+
+	scenes, err := c.ListScenes()
+	if err != nil {
+		t.Fatalf("ListScenes failed: %v", err)
+	}
+	if len(scenes) != 1 || scenes[0].Name != "Night" {
+		t.Errorf("unexpected scenes: %+v", scenes)
+	}
+	if err := c.TriggerScene(3); err != nil || triggered != 3 {
+		t.Errorf("TriggerScene: err = %v, triggered = %d", err, triggered)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := c.TriggerSceneContext(ctx, 4); !errors.Is(err, context.Canceled) || triggered != 3 {
+		t.Errorf("canceled TriggerSceneContext: err = %v, triggered = %d", err, triggered)
+	}
+}
+
+func TestPreserveUnknownFields(t *testing.T) {
+	var sent map[string]interface{}
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			body, _ := io.ReadAll(r.Body)
+			json.Unmarshal(body, &sent)
+			w.Write(body)
+			return
+		}
+		w.Write([]byte(`{"DeviceID":7,"DeviceType":0,"SetTemperature":20,"UnmodeledSetting":"keep-me"}`))
+	}, WithPreserveUnknownFields())
+
+	state, err := c.GetDeviceState(7, 1)
+	if err != nil {
+		t.Fatalf("GetDeviceState failed: %v", err)
+	}
+	newState := *state
+	newState.SetTargetTemperature(22)
+	if _, err := c.SetDeviceState(newState); err != nil {
+		t.Fatalf("SetDeviceState failed: %v", err)
+	}
+	if sent["UnmodeledSetting"] != "keep-me" || sent["SetTemperature"] != 22.0 {
+		t.Errorf("unexpected request body: %v", sent)
+	}
+}
+
+func TestListBuildings(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"ID":3,"Name":"Home","UseFahrenheit":true,"ProhibitOperationMode":true,
+			"FPEnabled":true,"FPMinTemperature":8,"FPMaxTemperature":12,
+			"Structure":{"Devices":[{"DeviceID":7}]}}]`))
+	})
+
+	buildings, err := c.ListBuildings(context.Background())
+	if err != nil {
+		t.Fatalf("ListBuildings failed: %v", err)
+	}
+	if len(buildings) != 1 || len(buildings[0].Structure.Devices) != 1 {
+		t.Fatalf("unexpected buildings %+v", buildings)
+	}
+	b := buildings[0]
+	if b.TemperatureUnit() != TemperatureUnitFahrenheit || !b.Prohibits() {
+		t.Errorf("unit %s, prohibits %t", b.TemperatureUnit(), b.Prohibits())
+	}
+	if min, max, ok := b.FrostProtection(); !ok || min != 8 || max != 12 {
+		t.Errorf("FrostProtection() = %v, %v, %t", min, max, ok)
+	}
+}
+
+func TestTemperatureUnit(t *testing.T) {
+	var sent map[string]interface{}
+	options := `{"UseFahrenheit":true,"EmailOnCommsError":true,"Language":4}`
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			if r.URL.Path != "/User/UpdateApplicationOptions" {
+				t.Errorf("unexpected request to %s", r.URL.Path)
+			}
+			json.NewDecoder(r.Body).Decode(&sent)
+			return
+		}
+		if r.URL.Path == "/User/GetApplicationOptions" {
+			w.Write([]byte(options))
+			return
+		}
+		w.Write([]byte(`[{"ID":3,"UseFahrenheit":true,"Structure":{}}]`))
+	})
+
+	if unit, err := c.TemperatureUnit(context.Background(), 3); err != nil || unit != TemperatureUnitFahrenheit {
+		t.Errorf("TemperatureUnit() = %q, %v", unit, err)
+	}
+	if err := c.SetTemperatureUnit("c"); err != nil {
+		t.Fatalf("SetTemperatureUnit failed: %v", err)
+	}
+	want := map[string]interface{}{"UseFahrenheit": false, "EmailOnCommsError": true, "Language": 4.0}
+	if !reflect.DeepEqual(sent, want) {
+		t.Errorf("sent %v, want %v", sent, want)
+	}
+	if err := c.SetTemperatureUnit("K"); err == nil {
+		t.Error("SetTemperatureUnit accepted an invalid unit")
+	}
+
+	sent = nil
+	options = `{"Language":4}`
+	if err := c.SetTemperatureUnit("F"); err == nil || sent != nil {
+		t.Errorf("options without UseFahrenheit: err = %v, sent %v", err, sent)
+	}
+}
+
+func TestStrictJSON(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/User/ListDevices") {
+			w.Write([]byte(`[{"Structure":{"Devices":[{"DeviceID":7,"DeviceName":"Hall",
+				"Device":{"MinTempHeat":10,"NewLimit":3}}]}}]`))
+			return
+		}
+		w.Write([]byte(`{"DeviceID":7,"DeviceType":0,"SetFanSpeed":2.0,"FirmwareFlavor":"x"}`))
+	}, WithStrictJSON())
+
+	_, err := c.ListDevices()
+	if !errors.Is(err, ErrUnknownField) || !strings.Contains(err.Error(), "Structure.Devices.Device.NewLimit") {
+		t.Errorf("ListDevices: err = %v, want ErrUnknownField for the nested field", err)
+	}
+	_, err = c.GetDeviceState(7, 2)
+	if !errors.Is(err, ErrUnknownField) || !strings.Contains(err.Error(), "FirmwareFlavor") {
+		t.Errorf("GetDeviceState: err = %v, want ErrUnknownField", err)
+	}
+
+	lenient := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"DeviceID":7,"DeviceType":0,"FirmwareFlavor":"x"}`))
+	})
+	if _, err := lenient.GetDeviceState(7, 2); err != nil {
+		t.Errorf("unknown fields rejected without WithStrictJSON: %v", err)
+	}
+}
+
+func TestCassette(t *testing.T) {
+	path := t.TempDir() + "/cassette.json"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"DeviceID":7,"DeviceType":0,"Power":true,"SetTemperature":21}`))
+	}))
+	rec := newClient(WithBaseURL(srv.URL), WithCassette(path, RecordModeRecord))
+	rec.token = "test-token"
+	recorded, err := rec.GetDeviceState(7, 2)
+	srv.Close()
+	if err != nil {
+		t.Fatalf("GetDeviceState while recording failed: %v", err)
+	}
+
+	// The server is gone, so the state can only come from the cassette
+	play := newClient(WithBaseURL(srv.URL), WithCassette(path, RecordModeReplay))
+	play.token = "test-token"
+	replayed, err := play.GetDeviceState(7, 2)
+	if err != nil {
+		t.Fatalf("GetDeviceState while replaying failed: %v", err)
+	}
+	if replayed.SetTemperature != recorded.SetTemperature || !replayed.Power {
+		t.Errorf("replayed %+v, recorded %+v", replayed, recorded)
+	}
+	if _, err := play.GetDeviceState(7, 2); !errors.Is(err, ErrCassetteMiss) {
+		t.Errorf("second replay: err = %v, want ErrCassetteMiss", err)
+	}
+
+	if got := redactBody([]byte(`{"Email":"a@b.c","Password":"secret"}`)); strings.Contains(got, "secret") {
+		t.Errorf("password not redacted: %s", got)
+	}
+}
+
+func TestBaseURLNormalization(t *testing.T) {
+	var paths []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.RequestURI())
+		w.Write([]byte(`[]`))
+	}))
+	defer srv.Close()
+
+	for _, base := range []string{srv.URL + "/", srv.URL + "//", strings.Replace(srv.URL, "http://", "HTTP://", 1) + "/"} {
+		c := newClient(WithBaseURL(base))
+		if want := srv.URL + "/User/ListDevices"; c.url("/User/ListDevices") != want || c.url("User/ListDevices") != want {
+			t.Errorf("base %q: url = %q, want %q", base, c.url("/User/ListDevices"), want)
+		}
+		if _, err := c.ListDevices(); err != nil {
+			t.Fatalf("base %q: ListDevices failed: %v", base, err)
+		}
+	}
+	for _, p := range paths {
+		if p != "/User/ListDevices" {
+			t.Errorf("server received request for %q", p)
+		}
+	}
+}
+
+func TestGzipResponses(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			t.Errorf("Accept-Encoding = %q, want gzip", r.Header.Get("Accept-Encoding"))
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		zw := gzip.NewWriter(w)
+		zw.Write([]byte(`{"DeviceID":7,"DeviceType":0,"SetTemperature":21.5}`))
+		zw.Close()
+	}
+
+	for name, opts := range map[string][]Option{
+		"default transport": nil,
+		"custom transport":  {WithTransport(&http.Transport{DisableCompression: true})},
+	} {
+		c := newTestClient(t, handler, opts...)
+		state, err := c.GetDeviceState(7, 2)
+		if err != nil {
+			t.Errorf("%s: GetDeviceState failed: %v", name, err)
+		} else if state.SetTemperature != 21.5 {
+			t.Errorf("%s: SetTemperature = %v, want 21.5", name, state.SetTemperature)
+		}
+	}
+}
+
+// closeTracker records whether a response body was closed.
+type closeTracker struct {
+	io.Reader
+	closed bool
+}
+
+func (c *closeTracker) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestDecompressClosesBody(t *testing.T) {
+	var buf strings.Builder
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte(`{}`))
+	zw.Close()
+
+	body := &closeTracker{Reader: strings.NewReader(buf.String())}
+	resp := &http.Response{Header: http.Header{"Content-Encoding": {"gzip"}}, Body: body}
+	raw, err := decompress(resp)
+	if err != nil {
+		t.Fatalf("decompress failed: %v", err)
+	}
+	if data, err := io.ReadAll(raw); err != nil || string(data) != `{}` {
+		t.Errorf("read %q, %v", data, err)
+	}
+	if err := raw.Close(); err != nil || !body.closed {
+		t.Errorf("Close() = %v, body closed %t", err, body.closed)
+	}
+}
+
+func TestMaxResponseSize(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"Structure":{"Devices":[{"DeviceName":"` + strings.Repeat("x", 1024) + `"}]}}]`))
+	}, WithMaxResponseSize(512))
+
+	_, err := c.ListDevices()
+	if !errors.Is(err, ErrResponseTooLarge) {
+		t.Fatalf("expected ErrResponseTooLarge, got %v", err)
+	}
+
+	c.maxResponseSize = 4096
+	if _, err := c.ListDevices(); err != nil {
+		t.Fatalf("ListDevices failed within the size limit: %v", err)
+	}
+}
+
+func TestErrorIncludesDeviceName(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/User/ListDevices" {
+			w.Write([]byte(`[{"Structure":{"Devices":[{"DeviceID":12345,"BuildingID":1,"DeviceName":"Living Room"}]}}]`))
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	if _, err := c.GetDeviceState(12345, 1); err == nil || !strings.Contains(err.Error(), "device 12345 in building 1") {
+		t.Errorf("expected bare device ID before ListDevices, got %v", err)
+	}
+
+	if _, err := c.ListDevices(); err != nil {
+		t.Fatalf("ListDevices failed: %v", err)
+	}
+	if _, err := c.GetDeviceState(12345, 1); err == nil || !strings.Contains(err.Error(), "device 12345 (Living Room)") {
+		t.Errorf("expected device name in error, got %v", err)
+	}
+}
+
+func TestNudgeTemperature(t *testing.T) {
+	dev := &Device{TemperatureIncrement: 0.5, MinTempHeat: 10, MaxTempHeat: 31, MinTempCoolDry: 16, MaxTempCoolDry: 31}
+
+	tests := []struct {
+		name string
+		mode int
+		temp float64
+		dir  int
+		want float64
+	}{
+		{"up", OpModeHeat, 21, 1, 21.5},
+		{"down", OpModeHeat, 21, -1, 20.5},
+		{"up at max", OpModeHeat, 31, 1, 31},
+		{"down at min", OpModeHeat, 10, -1, 10},
+		{"down at cool min", OpModeCool, 16, -1, 16},
+		{"below range clamps to min", OpModeCool, 12, 1, 16},
+		{"no range for auto", OpModeHeatCool, 21, 1, 21.5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := AtaDeviceState{OperationMode: tt.mode, SetTemperature: tt.temp}
+			s.NudgeTemperature(tt.dir, dev)
+			if s.SetTemperature != tt.want {
+				t.Errorf("SetTemperature = %.1f, want %.1f", s.SetTemperature, tt.want)
+			}
+			if s.EffectiveFlags&FlagTargetTemp == 0 {
+				t.Error("FlagTargetTemp not set")
+			}
+		})
+	}
+
+	// Without a device the default half-degree step applies, unclamped
+	s := AtaDeviceState{OperationMode: OpModeHeat, SetTemperature: 21}
+	s.NudgeTemperature(1, nil)
+	if s.SetTemperature != 21.5 || s.EffectiveFlags != FlagTargetTemp {
+		t.Errorf("nil device: SetTemperature = %.1f, flags %#x", s.SetTemperature, s.EffectiveFlags)
+	}
+
+	// Nudging onto 0 is ignored outside a range including it
+	s = AtaDeviceState{OperationMode: OpModeHeatCool, SetTemperature: 0.5}
+	s.NudgeTemperature(-1, dev)
+	if s.SetTemperature != 0.5 || s.EffectiveFlags != 0 {
+		t.Errorf("nudge to zero: SetTemperature = %.1f, flags %#x", s.SetTemperature, s.EffectiveFlags)
+	}
+}
+
+// Helper function for logging token prefix
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// quickStateFixture is a Get response with every modeled field present.
+var quickStateFixture, _ = json.Marshal(AtaDeviceState{
+	DeviceID: 7, DeviceName: "Hall", MacAddress: "00:1a:2b:3c:4d:5e", SerialNumber: "123",
+	Power: true, RoomTemperature: 21.5, SetTemperature: 22, OperationMode: OpModeCool,
+	LastCommunication: "2024-01-02T15:04:05.123", ErrorCodes: []int{5101},
+	HolidayModeStartDate: "2024-02-01T00:00:00", HolidayModeEndDate: "2024-02-10T00:00:00",
+})
+
+func TestQuickState(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write(quickStateFixture)
+	}, WithStrictJSON())
+
+	power, room, err := c.QuickState(7, 2)
+	if err != nil || !power || room != 21.5 {
+		t.Errorf("QuickState() = %t, %v, %v; want true, 21.5", power, room, err)
+	}
+}
+
+// BenchmarkDecodeState and BenchmarkDecodeQuickState compare the decoding cost of
+// GetDeviceState and QuickState on the same response.
+func BenchmarkDecodeState(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var s AtaDeviceState
+		if err := json.Unmarshal(quickStateFixture, &s); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecodeQuickState(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var s quickState
+		if err := json.Unmarshal(quickStateFixture, &s); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// largeAccount builds a building structure with devices spread over floors and areas,
+// some listed twice, as in large MELCloud accounts.
+func largeAccount(floors, areas, perArea int) []Building {
+	var s Structure
+	id := 0
+	for f := 1; f <= floors; f++ {
+		floor := Floor{ID: f}
+		for a := 1; a <= areas; a++ {
+			area := Area{ID: f*100 + a}
+			for d := 0; d < perArea; d++ {
+				id++
+				area.Devices = append(area.Devices, Device{DeviceID: id, DeviceName: "Unit " + strconv.Itoa(id)})
+			}
+			floor.Areas = append(floor.Areas, area)
+		}
+		floor.Devices = append(floor.Devices, floor.Areas[0].Devices...) // Duplicates
+		s.Floors = append(s.Floors, floor)
+	}
+	return []Building{{Structure: s}}
+}
+
+func BenchmarkFlattenBuildings(b *testing.B) {
+	buildings := largeAccount(10, 10, 5)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if devices := flattenBuildings(buildings); len(devices) != 500 {
+			b.Fatalf("got %d devices, want 500", len(devices))
+		}
 	}
-	return b
 }
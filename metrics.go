@@ -0,0 +1,50 @@
+package melcloud
+
+import "strconv"
+
+// Metrics returns the numeric fields of the state keyed by snake_case names, for
+// ingestion into time-series databases. Booleans are reported as 0 or 1. Readings the
+// unit may not report at all, such as the compressor frequency, are omitted when absent.
+func (s *AtaDeviceState) Metrics() map[string]float64 {
+	metrics := map[string]float64{
+		"power":                boolMetric(s.Power),
+		"room_temperature":     s.RoomTemperature,
+		"set_temperature":      s.SetTemperature,
+		"operation_mode":       float64(s.OperationMode),
+		"fan_speed":            float64(s.SetFanSpeed),
+		"demand_percentage":    float64(s.DemandPercentage),
+		"outdoor_temperature":  s.OutdoorTemperature,
+		"wifi_signal_strength": float64(s.WifiSignalStrength),
+		"operating_hours":      s.OperatingHours,
+		"has_error":            boolMetric(s.HasError),
+		"defrosting":           boolMetric(s.IsDefrosting()),
+		"grid_control":         boolMetric(s.UnderGridControl()),
+	}
+	if hz, ok := s.CompressorFrequency(); ok {
+		metrics["compressor_frequency"] = float64(hz)
+	}
+	return metrics
+}
+
+// Tags returns identity strings for the device, to label the values from Metrics.
+// Empty identifiers are omitted.
+func (s *AtaDeviceState) Tags() map[string]string {
+	tags := map[string]string{
+		"device_id":   strconv.Itoa(s.DeviceID),
+		"building_id": strconv.Itoa(s.BuildingID),
+	}
+	if s.MacAddress != "" {
+		tags["mac_address"] = s.MacAddress
+	}
+	if s.SerialNumber != "" {
+		tags["serial_number"] = s.SerialNumber
+	}
+	return tags
+}
+
+func boolMetric(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,26 @@
+package melcloud
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+)
+
+// flexInt decodes a JSON number written either as an integer or as a float with no
+// fractional part (2 or 2.0). MELCloud is inconsistent about this for some fields.
+type flexInt int
+
+func (n *flexInt) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	var f float64
+	if err := json.Unmarshal(data, &f); err != nil {
+		return err
+	}
+	if f != math.Trunc(f) {
+		return fmt.Errorf("expected an integer, got %s", data)
+	}
+	*n = flexInt(f)
+	return nil
+}
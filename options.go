@@ -0,0 +1,209 @@
+package melcloud
+
+import (
+	"net/http"
+	"time"
+)
+
+// defaultMaxResponseSize caps how much of a response body is decoded.
+// MELCloud responses are a few kilobytes; even large accounts stay well below this.
+const defaultMaxResponseSize = 4 << 20 // 4 MB
+
+// defaultMaxConcurrency is the number of requests helpers such as Snapshot run in parallel.
+const defaultMaxConcurrency = 4
+
+// defaultIdleConnTimeout is how long idle connections are kept. The client only talks to
+// one host with modest concurrency, so by default it keeps as many idle connections as
+// requests it runs in parallel (see WithMaxConcurrency), avoiding reconnects between bursts.
+const defaultIdleConnTimeout = 90 * time.Second
+
+// Option configures a Client created by Login.
+type Option func(*Client)
+
+// WithBaseURL overrides the MELCloud API base URL (useful for proxies and tests).
+// Trailing slashes are ignored and the scheme and host are lower-cased.
+func WithBaseURL(url string) Option {
+	return func(c *Client) {
+		c.baseURL = normalizeBaseURL(url)
+	}
+}
+
+// WithTimeout sets the timeout for each API request (default 10s).
+// LoginContext can give the login request a different deadline through its context.
+func WithTimeout(d time.Duration) Option {
+	return func(c *Client) {
+		c.httpClient.Timeout = d
+	}
+}
+
+// WithMaxResponseSize limits the number of bytes read from any response body.
+// Responses exceeding the limit fail with ErrResponseTooLarge.
+// A value <= 0 disables the limit.
+func WithMaxResponseSize(n int64) Option {
+	return func(c *Client) {
+		c.maxResponseSize = n
+	}
+}
+
+// WithMinRequestInterval spaces out requests so that at most one is sent per interval,
+// across all goroutines using the client. MELCloud rate limits aggressive clients.
+func WithMinRequestInterval(d time.Duration) Option {
+	return func(c *Client) {
+		c.minRequestInterval = d
+	}
+}
+
+// WithMaxConcurrency sets how many requests multi-device helpers such as Snapshot
+// run in parallel. Values < 1 are treated as 1.
+func WithMaxConcurrency(n int) Option {
+	return func(c *Client) {
+		if n < 1 {
+			n = 1
+		}
+		c.maxConcurrency = n
+	}
+}
+
+// WithMaxIdleConns sets how many idle connections to MELCloud are kept for reuse
+// (default: the WithMaxConcurrency limit, 4 unless changed). Ignored when WithTransport is used.
+func WithMaxIdleConns(n int) Option {
+	return func(c *Client) {
+		c.maxIdleConns = n
+	}
+}
+
+// WithIdleConnTimeout sets how long an idle connection is kept before closing it
+// (default 90s). Ignored when WithTransport is used.
+func WithIdleConnTimeout(d time.Duration) Option {
+	return func(c *Client) {
+		c.idleConnTimeout = d
+	}
+}
+
+// WithTransport uses a pre-configured transport for all requests instead of the
+// client's own tuned copy of http.DefaultTransport.
+func WithTransport(rt http.RoundTripper) Option {
+	return func(c *Client) {
+		c.transport = rt
+	}
+}
+
+// WithAutoRound makes SetDeviceState round a flagged target temperature to the device's
+// increment and clamp it to the range of the state's operation mode before sending.
+// This changes the default behavior, where temperatures are sent exactly as set.
+// Capabilities come from the last ListDevices call; temperatures for devices that have
+// not been listed are sent unchanged.
+func WithAutoRound() Option {
+	return func(c *Client) {
+		c.autoRound = true
+	}
+}
+
+// WithCircuitBreaker stops sending requests for cooldown after threshold consecutive
+// failures (network errors, 429 or 5xx responses). While open, calls fail immediately with
+// ErrCircuitOpen, so an outage isn't made worse by retries that could get the account
+// flagged. The first request after the cooldown closes the breaker again if it succeeds.
+func WithCircuitBreaker(threshold int, cooldown time.Duration) Option {
+	return func(c *Client) {
+		if threshold < 1 {
+			threshold = 1
+		}
+		c.breaker = &circuitBreaker{threshold: threshold, cooldown: cooldown}
+	}
+}
+
+// WithPollJitter delays Snapshot by a random duration of up to max before it starts, and
+// adds the same random delay to each WaitFor poll interval. Many deployments polling on
+// the same schedule (e.g. every full minute) otherwise hit MELCloud at the same moment and
+// trigger its rate limits.
+func WithPollJitter(max time.Duration) Option {
+	return func(c *Client) {
+		c.pollJitter = max
+	}
+}
+
+// WithPreserveUnknownFields keeps the JSON of states read from MELCloud and merges the
+// modeled fields into it when sending SetDeviceState. Fields this library does not model are
+// then sent back unchanged instead of being dropped, which could otherwise reset them
+// server-side. States not read through this client are sent as usual.
+func WithPreserveUnknownFields() Option {
+	return func(c *Client) {
+		c.preserveUnknown = true
+	}
+}
+
+// WithDuplicateSuppression makes SetDeviceState refuse to resend a command identical to the
+// last one sent to the same device within window, returning ErrDuplicateCommand instead.
+// This keeps a retry after an ambiguous failure (e.g. an error status or a response that
+// could not be read) from applying a command twice. Commands only count as sent once MELCloud
+// responded, so one that never reached it (circuit open, throttled, cancelled or failed to
+// connect) can be retried right away. MELCloud has no idempotency keys, so this is
+// client-side only.
+func WithDuplicateSuppression(window time.Duration) Option {
+	return func(c *Client) {
+		c.dedupWindow = window
+	}
+}
+
+// WithConditionalRequests makes ListDevices send If-None-Match and If-Modified-Since with
+// the validators of the previous response, and return the previous device list when the
+// server answers 304 Not Modified. MELCloud does not document support for this; when a
+// response carries no ETag or Last-Modified headers, its list is reused for one minute
+// instead, like a short WithDeviceListCache (which takes precedence when also set).
+func WithConditionalRequests() Option {
+	return func(c *Client) {
+		c.conditional = true
+	}
+}
+
+// WithDeviceListCache makes ListDevices, and helpers built on it such as BuildIndex, serve
+// the device list from memory for ttl after fetching it. Device identity and capabilities
+// rarely change; call InvalidateCache after adding or removing units. Device states are
+// never cached.
+func WithDeviceListCache(ttl time.Duration) Option {
+	return func(c *Client) {
+		c.listCacheTTL = ttl
+	}
+}
+
+// WithPersistSession sets the Persist flag of the login request (default true). A
+// persistent session stays valid for a long time, typically without MELCloud reporting a
+// LoginMinutes lifetime. With false, the session expires after LoginMinutes, which
+// SessionExpiry reports; requests after that fail and require a new Login. Use false on
+// shared machines or for short-lived scripts.
+func WithPersistSession(persist bool) Option {
+	return func(c *Client) {
+		c.persistSession = persist
+	}
+}
+
+// WithRollbackOnPartialApply makes SetDeviceState revert a partially applied command: it
+// reads the device's state before sending, and if MELCloud reports that only some fields
+// changed, sends the previous values of those fields back. This costs an extra request per
+// set and is best effort; the returned *PartialApplyError reports whether it succeeded.
+func WithRollbackOnPartialApply() Option {
+	return func(c *Client) {
+		c.rollback = true
+	}
+}
+
+// WithStrictJSON makes responses with fields this library does not model fail with
+// ErrUnknownField, including fields of device lists and states. It is meant for catching
+// schema drift against recorded fixtures in CI; live MELCloud responses carry many
+// unmodeled fields, so leave it off in production.
+func WithStrictJSON() Option {
+	return func(c *Client) {
+		c.strictJSON = true
+	}
+}
+
+// WithCassette records HTTP interactions to the file at path, or replays them from it,
+// so tests can run without credentials or network access. RecordModeRecord overwrites the
+// file as requests are made; RecordModeReplay serves recorded responses in order and fails
+// unmatched requests with ErrCassetteMiss. Login passwords are redacted from recordings,
+// but other data (including the session key and device details) is stored as received.
+func WithCassette(path string, mode RecordMode) Option {
+	return func(c *Client) {
+		c.cassette = &cassette{path: path, mode: mode}
+	}
+}
@@ -0,0 +1,82 @@
+package melcloud
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+)
+
+// DevicePreset is a named set of settings stored for a device in the MELCloud app.
+// Values use MELCloud's raw numbering, like AtaDeviceState.
+type DevicePreset struct {
+	ID             int     `json:"ID"`
+	Name           string  `json:"NumberDescription"`
+	Power          bool    `json:"Power"`
+	OperationMode  int     `json:"OperationMode"` // OpMode* constant
+	SetTemperature float64 `json:"SetTemperature"`
+	FanSpeed       int     `json:"FanSpeed"` // 0: auto, 1-N: speeds
+	VaneVertical   int     `json:"VaneVertical"`
+	VaneHorizontal int     `json:"VaneHorizontal"`
+}
+
+// Apply sets the preset's settings on s, setting the matching EffectiveFlags.
+// As with StateUpdate.Apply, the temperature is not flagged for fan-only presets.
+func (p *DevicePreset) Apply(s *AtaDeviceState) error {
+	s.SetPower(p.Power)
+	mode, ok := opModeIntToString[p.OperationMode]
+	if !ok {
+		return fmt.Errorf("preset %q: invalid operation mode: %d", p.Name, p.OperationMode)
+	}
+	if err := s.SetOperationMode(mode); err != nil {
+		return err
+	}
+	if p.SetTemperature != 0 {
+		s.SetTargetTemperature(p.SetTemperature)
+	}
+	fan := FanAuto
+	if p.FanSpeed != FanSpeedAuto {
+		fan = strconv.Itoa(p.FanSpeed)
+	}
+	if err := s.SetFanSpeedMode(fan); err != nil {
+		return err
+	}
+	if err := s.SetVaneVerticalInt(p.VaneVertical); err != nil {
+		return err
+	}
+	return s.SetVaneHorizontalInt(p.VaneHorizontal)
+}
+
+// ListDevicePresets returns the presets stored for a device in the MELCloud app.
+func (c *Client) ListDevicePresets(deviceID int) ([]DevicePreset, error) {
+	return c.ListDevicePresetsContext(context.Background(), deviceID)
+}
+
+// ListDevicePresetsContext is like ListDevicePresets but honors the context for cancellation.
+func (c *Client) ListDevicePresetsContext(ctx context.Context, deviceID int) ([]DevicePreset, error) {
+	var presets []DevicePreset
+	if err := c.Do(ctx, "GET", fmt.Sprintf("/Device/ListPresets?id=%d", deviceID), nil, &presets); err != nil {
+		return nil, fmt.Errorf("%s: %w", c.deviceLabel(deviceID), err)
+	}
+	return presets, nil
+}
+
+// ApplyDevicePreset looks up one of the device's presets by its ID and sends its settings
+// to the device with SetDeviceState.
+func (c *Client) ApplyDevicePreset(deviceID, buildingID, presetID int) error {
+	return c.ApplyDevicePresetContext(context.Background(), deviceID, buildingID, presetID)
+}
+
+// ApplyDevicePresetContext is like ApplyDevicePreset but honors the context for cancellation.
+func (c *Client) ApplyDevicePresetContext(ctx context.Context, deviceID, buildingID, presetID int) error {
+	presets, err := c.ListDevicePresetsContext(ctx, deviceID)
+	if err != nil {
+		return err
+	}
+	for i := range presets {
+		if presets[i].ID == presetID {
+			_, err := c.updateDeviceState(ctx, deviceID, buildingID, presets[i].Apply)
+			return err
+		}
+	}
+	return fmt.Errorf("%s: preset %d not found", c.deviceLabel(deviceID), presetID)
+}
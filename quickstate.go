@@ -0,0 +1,45 @@
+package melcloud
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// quickState holds the few fields QuickState decodes from a Get response.
+type quickState struct {
+	DeviceType      int     `json:"DeviceType"`
+	Power           bool    `json:"Power"`
+	RoomTemperature float64 `json:"RoomTemperature"`
+}
+
+// QuickState fetches only whether a device is on and its room temperature. MELCloud has no
+// lighter endpoint, so it makes the same request as GetDeviceState, but decodes just these
+// fields, allocating far less for dashboards that poll often. WithStrictJSON and
+// WithPreserveUnknownFields do not apply.
+func (c *Client) QuickState(deviceID, buildingID int) (power bool, roomTemp float64, err error) {
+	return c.QuickStateContext(context.Background(), deviceID, buildingID)
+}
+
+// QuickStateContext is like QuickState but honors the context for cancellation.
+func (c *Client) QuickStateContext(ctx context.Context, deviceID, buildingID int) (power bool, roomTemp float64, err error) {
+	if d, ok := c.knownDevice(deviceID); ok && d.DeviceType != DeviceTypeAta {
+		return false, 0, fmt.Errorf("%s: %w: %d", c.deviceLabel(deviceID), ErrUnsupportedDeviceType, d.DeviceType)
+	}
+
+	url := c.url(fmt.Sprintf("/Device/Get?id=%d&buildingID=%d", deviceID, buildingID))
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return false, 0, fmt.Errorf("failed to create get device state request: %w", err)
+	}
+	c.setHeaders(req)
+
+	var state quickState
+	if err := c.doJSON(req, "get device state", &state); err != nil {
+		return false, 0, fmt.Errorf("%s in building %d: %w", c.deviceLabel(deviceID), buildingID, err)
+	}
+	if state.DeviceType != DeviceTypeAta {
+		return false, 0, fmt.Errorf("%s: %w: %d", c.deviceLabel(deviceID), ErrUnsupportedDeviceType, state.DeviceType)
+	}
+	return state.Power, state.RoomTemperature, nil
+}
@@ -0,0 +1,69 @@
+package melcloud
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// rateWindow is the period over which RateBudget counts requests.
+const rateWindow = time.Minute
+
+// recordRequest notes a sent request for RateBudget. A 429 response pauses the budget for
+// its Retry-After delay, or a full rateWindow if the server gave none.
+func (c *Client) recordRequest(resp *http.Response) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	c.recentRequests = append(c.pruneRequests(now), now)
+
+	if resp != nil && resp.StatusCode == http.StatusTooManyRequests {
+		backoff := rateWindow
+		if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && secs > 0 {
+			backoff = time.Duration(secs) * time.Second
+		}
+		c.throttledUntil = now.Add(backoff)
+	}
+}
+
+// pruneRequests drops recorded requests older than rateWindow. c.mu must be held.
+func (c *Client) pruneRequests(now time.Time) []time.Time {
+	i := 0
+	for i < len(c.recentRequests) && now.Sub(c.recentRequests[i]) >= rateWindow {
+		i++
+	}
+	c.recentRequests = c.recentRequests[i:]
+	return c.recentRequests
+}
+
+// RateBudget estimates how many requests can be sent right now without being throttled,
+// and how long until the budget next grows. After a 429 response the budget is 0 until the
+// server's Retry-After delay has passed. Otherwise, with WithMinRequestInterval, the budget
+// is the number of requests the interval allows per minute minus those sent in the last
+// minute. Without either, remaining is -1: no limit is known.
+func (c *Client) RateBudget() (remaining int, resetIn time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	recent := c.pruneRequests(now)
+
+	if now.Before(c.throttledUntil) {
+		return 0, c.throttledUntil.Sub(now)
+	}
+	if c.minRequestInterval <= 0 {
+		return -1, 0
+	}
+
+	capacity := int(rateWindow / c.minRequestInterval)
+	if capacity < 1 {
+		capacity = 1
+	}
+	remaining = capacity - len(recent)
+	if remaining < 0 {
+		remaining = 0
+	}
+	if len(recent) > 0 {
+		resetIn = rateWindow - now.Sub(recent[0])
+	}
+	return remaining, resetIn
+}
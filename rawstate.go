@@ -0,0 +1,51 @@
+package melcloud
+
+import "encoding/json"
+
+// rawState decodes into an AtaDeviceState and keeps the original JSON on it,
+// so SetDeviceState can send back fields the struct does not model.
+type rawState struct {
+	state *AtaDeviceState
+}
+
+func (r *rawState) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, r.state); err != nil {
+		return err
+	}
+	r.state.raw = append(json.RawMessage(nil), data...)
+	return nil
+}
+
+// stateTarget returns the value to decode a state response into.
+func (c *Client) stateTarget(state *AtaDeviceState) interface{} {
+	if c.preserveUnknown {
+		return &rawState{state}
+	}
+	return state
+}
+
+// marshalState encodes a state for SetAta. With WithPreserveUnknownFields, the modeled
+// fields are merged over the JSON the state was originally read from. DeviceName is
+// read-only metadata filled in from ListDevices and is left out.
+func (c *Client) marshalState(state *AtaDeviceState) ([]byte, error) {
+	payload := *state
+	payload.DeviceName = ""
+	modeled, err := json.Marshal(&payload)
+	if err != nil || !c.preserveUnknown || len(state.raw) == 0 {
+		return modeled, err
+	}
+
+	var merged map[string]json.RawMessage
+	if err := json.Unmarshal(state.raw, &merged); err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(modeled, &fields); err != nil {
+		return nil, err
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+	delete(merged, "DeviceName")
+	return json.Marshal(merged)
+}
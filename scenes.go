@@ -0,0 +1,43 @@
+package melcloud
+
+import (
+	"context"
+	"fmt"
+)
+
+// Scene is a server-side scene configured in the MELCloud app. Triggering a scene
+// applies its stored settings to all of its devices.
+type Scene struct {
+	SceneID    int    `json:"SceneID"`
+	Name       string `json:"SceneName"`
+	BuildingID int    `json:"BuildingID"`
+	Enabled    bool   `json:"Enabled"`
+}
+
+// ListScenes returns the scenes configured on the account.
+func (c *Client) ListScenes() ([]Scene, error) {
+	return c.ListScenesContext(context.Background())
+}
+
+// ListScenesContext is like ListScenes but honors the context for cancellation.
+func (c *Client) ListScenesContext(ctx context.Context) ([]Scene, error) {
+	var scenes []Scene
+	if err := c.Do(ctx, "GET", "/Scene/GetScenes", nil, &scenes); err != nil {
+		return nil, err
+	}
+	return scenes, nil
+}
+
+// TriggerScene activates a scene by its SceneID.
+func (c *Client) TriggerScene(sceneID int) error {
+	return c.TriggerSceneContext(context.Background(), sceneID)
+}
+
+// TriggerSceneContext is like TriggerScene but honors the context for cancellation.
+func (c *Client) TriggerSceneContext(ctx context.Context, sceneID int) error {
+	body := map[string]interface{}{"SceneID": sceneID}
+	if err := c.Do(ctx, "POST", "/Scene/Activate", body, nil); err != nil {
+		return fmt.Errorf("scene %d: %w", sceneID, err)
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package melcloud
+
+import (
+	"fmt"
+	"time"
+)
+
+// ScheduleEntry is one action of a device's weekly timer, in the device's local time.
+type ScheduleEntry struct {
+	Day            time.Weekday `json:"Day"`
+	Hour           int          `json:"Hour"`
+	Minute         int          `json:"Minute"`
+	Power          bool         `json:"Power"`
+	OperationMode  int          `json:"OperationMode"`  // OpMode* constant, 0 keeps the current mode
+	SetTemperature float64      `json:"SetTemperature"` // 0 keeps the current setpoint
+}
+
+// Schedule is a device's weekly timer. Entries repeat every week.
+type Schedule struct {
+	Enabled bool            `json:"Enabled"`
+	Entries []ScheduleEntry `json:"Entries"`
+}
+
+// NextAction returns the first entry that fires strictly after the given time, together
+// with the absolute time it fires in loc (the device's timezone). ok is false if the
+// schedule is disabled or empty. If several entries fire at the same time, the first
+// one listed is returned.
+func (s *Schedule) NextAction(after time.Time, loc *time.Location) (entry *ScheduleEntry, at time.Time, ok bool) {
+	if !s.Enabled {
+		return nil, time.Time{}, false
+	}
+	local := after.In(loc)
+	for i := range s.Entries {
+		next := s.Entries[i].nextAfter(local)
+		if entry == nil || next.Before(at) {
+			entry, at = &s.Entries[i], next
+		}
+	}
+	return entry, at, entry != nil
+}
+
+// nextAfter returns the first time after t (in t's location) at which e fires.
+func (e *ScheduleEntry) nextAfter(t time.Time) time.Time {
+	days := (int(e.Day) - int(t.Weekday()) + 7) % 7
+	next := time.Date(t.Year(), t.Month(), t.Day()+days, e.Hour, e.Minute, 0, 0, t.Location())
+	if !next.After(t) {
+		next = time.Date(t.Year(), t.Month(), t.Day()+days+7, e.Hour, e.Minute, 0, 0, t.Location())
+	}
+	return next
+}
+
+// Kinds of ScheduleWarning.
+const (
+	DSTGap     = "gap"     // The entry's time is skipped when clocks go forward
+	DSTOverlap = "overlap" // The entry's time occurs twice when clocks go back
+)
+
+// ScheduleWarning reports a schedule entry that falls into a daylight saving time
+// transition on a particular day, so it may fire at an unexpected time or not as intended.
+type ScheduleWarning struct {
+	Entry *ScheduleEntry
+	Date  time.Time // Midnight of the affected day, in the device's timezone
+	Kind  string    // DSTGap or DSTOverlap
+}
+
+func (w ScheduleWarning) String() string {
+	what := "does not exist"
+	if w.Kind == DSTOverlap {
+		what = "occurs twice"
+	}
+	return fmt.Sprintf("%s entry at %02d:%02d %s on %s because of a daylight saving time change",
+		w.Entry.Day, w.Entry.Hour, w.Entry.Minute, what, w.Date.Format("2006-01-02"))
+}
+
+// dstShifts are the clock changes checked for overlaps. Most zones shift by an hour;
+// a few (e.g. Australia/Lord_Howe) by 30 minutes.
+var dstShifts = []time.Duration{time.Hour, 30 * time.Minute}
+
+// DSTWarnings checks every day from from up to to in loc, the device's timezone, and
+// returns a warning for each entry that falls into a DST gap or overlap on that day.
+// Warnings are in chronological order. Checking the coming year catches all transitions.
+func (s *Schedule) DSTWarnings(loc *time.Location, from, to time.Time) []ScheduleWarning {
+	var warnings []ScheduleWarning
+	start := from.In(loc)
+	for i := 0; ; i++ {
+		day := time.Date(start.Year(), start.Month(), start.Day()+i, 0, 0, 0, 0, loc)
+		if !day.Before(to) {
+			break
+		}
+		for j := range s.Entries {
+			e := &s.Entries[j]
+			if e.Day != day.Weekday() {
+				continue
+			}
+			if kind := dstConflict(day, e.Hour, e.Minute); kind != "" {
+				warnings = append(warnings, ScheduleWarning{Entry: e, Date: day, Kind: kind})
+			}
+		}
+	}
+	return warnings
+}
+
+// dstConflict reports whether the wall-clock time hour:minute on day is skipped (DSTGap)
+// or repeated (DSTOverlap) in day's location, or "" if it occurs exactly once.
+func dstConflict(day time.Time, hour, minute int) string {
+	t := time.Date(day.Year(), day.Month(), day.Day(), hour, minute, 0, 0, day.Location())
+	if t.Hour() != hour || t.Minute() != minute {
+		return DSTGap // time.Date moved the nonexistent time across the gap
+	}
+	for _, shift := range dstShifts {
+		for _, other := range []time.Time{t.Add(shift), t.Add(-shift)} {
+			if sameWallClock(t, other) {
+				return DSTOverlap
+			}
+		}
+	}
+	return ""
+}
+
+func sameWallClock(a, b time.Time) bool {
+	ay, am, ad := a.Date()
+	by, bm, bd := b.Date()
+	return ay == by && am == bm && ad == bd && a.Hour() == b.Hour() && a.Minute() == b.Minute()
+}
@@ -0,0 +1,83 @@
+package melcloud
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+)
+
+// SetpointRange is the allowed setpoint range for one operation mode, in °C.
+type SetpointRange struct {
+	Min float64 `json:"min"`
+	Max float64 `json:"max"`
+}
+
+// ControlSchema lists the controls a device supports and their valid values, using the
+// same strings as the AtaDeviceState setters. It is meant to be sent to front-ends as JSON.
+type ControlSchema struct {
+	DeviceID        int                      `json:"device_id"`
+	Controls        Controls                 `json:"controls"`
+	Modes           []string                 `json:"modes"`
+	FanSpeeds       []string                 `json:"fan_speeds"`      // Empty if the fan speed cannot be set
+	VaneVertical    []string                 `json:"vane_vertical"`   // Empty if the vertical vane cannot be set
+	VaneHorizontal  []string                 `json:"vane_horizontal"` // Empty if the horizontal vane cannot be set
+	TemperatureStep float64                  `json:"temperature_step"`
+	Temperature     map[string]SetpointRange `json:"temperature"` // Keyed by mode; modes without a reported range are omitted
+}
+
+// Positions in display order, keyed by the strings of vaneVertStringToInt/vaneHorizStringToInt.
+var (
+	vaneVerticalOptions   = []string{VaneAuto, "1", "2", "3", "4", "5", VaneSwing}
+	vaneHorizontalOptions = []string{VaneAuto, "1", "2", "3", "4", "5", VaneSplit, VaneSwing}
+)
+
+// ControlSchema derives the device's control schema from its capabilities.
+func (d *Device) ControlSchema() ControlSchema {
+	controls := d.WritableControls()
+	schema := ControlSchema{
+		DeviceID:        d.DeviceID,
+		Controls:        controls,
+		Modes:           d.SupportedModes(),
+		TemperatureStep: d.TemperatureStep(),
+		Temperature:     make(map[string]SetpointRange),
+	}
+
+	if controls.FanSpeed {
+		min, max, auto := d.FanSpeedBounds()
+		if auto {
+			schema.FanSpeeds = append(schema.FanSpeeds, FanAuto)
+		}
+		for speed := min; speed <= max; speed++ {
+			schema.FanSpeeds = append(schema.FanSpeeds, strconv.Itoa(speed))
+		}
+	}
+	if controls.VaneV {
+		schema.VaneVertical = slices.Clone(vaneVerticalOptions)
+	}
+	if controls.VaneH {
+		schema.VaneHorizontal = slices.Clone(vaneHorizontalOptions)
+	}
+
+	for _, mode := range schema.Modes {
+		if min, max, ok := d.TemperatureRange(opModeStringToInt[mode]); ok {
+			schema.Temperature[mode] = SetpointRange{Min: min, Max: max}
+		}
+	}
+	return schema
+}
+
+// DeviceControlSchema returns the control schema of a device, from the last ListDevices
+// result or, if the device is not known yet, by listing devices.
+func (c *Client) DeviceControlSchema(deviceID int) (ControlSchema, error) {
+	d, ok := c.knownDevice(deviceID)
+	if !ok {
+		idx, err := c.BuildIndex()
+		if err != nil {
+			return ControlSchema{}, err
+		}
+		if d, ok = idx.ByID[deviceID]; !ok {
+			return ControlSchema{}, fmt.Errorf("device %d not found on this account", deviceID)
+		}
+	}
+	return d.ControlSchema(), nil
+}
@@ -0,0 +1,114 @@
+package melcloud
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// DeviceSnapshot bundles a device's metadata with its current state.
+// Err is set instead of State when the state could not be fetched.
+type DeviceSnapshot struct {
+	Device Device
+	State  *AtaDeviceState
+	Err    error
+
+	// When this client last sent the device a command, zero if it has not; see StuckCommands
+	CommandSentAt time.Time
+}
+
+// Snapshot lists all devices and fetches their current state concurrently,
+// bounded by WithMaxConcurrency and paced by WithMinRequestInterval.
+// A device whose state cannot be fetched is included with its error rather than
+// failing the whole snapshot; only a ListDevices failure returns an error.
+// With WithPollJitter, the snapshot starts after a random delay.
+func (c *Client) Snapshot(ctx context.Context) ([]DeviceSnapshot, error) {
+	if err := sleepContext(ctx, c.jitter()); err != nil {
+		return nil, err
+	}
+
+	devices, err := c.ListDevicesContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshots := make([]DeviceSnapshot, len(devices))
+	c.forEach(len(devices), func(i int) {
+		d := devices[i]
+		state, err := c.GetDeviceStateContext(ctx, d.DeviceID, d.BuildingID)
+		snapshots[i] = DeviceSnapshot{Device: d, State: state, Err: err, CommandSentAt: c.commandSentAt(d.DeviceID)}
+	})
+
+	return snapshots, nil
+}
+
+// StuckCommands returns the snapshots of devices that have had a command pending for longer
+// than threshold at time now, i.e. units that accept commands but never apply them.
+// The age of a pending command is measured from CommandSentAt. For commands sent by other
+// clients (e.g. the MELCloud app) it falls back to the device's LastCommunication, which
+// only catches units that have also stopped communicating.
+func StuckCommands(snapshots []DeviceSnapshot, threshold time.Duration, now time.Time) []DeviceSnapshot {
+	var stuck []DeviceSnapshot
+	for _, snap := range snapshots {
+		if snap.State == nil || !snap.State.HasPendingCommand {
+			continue
+		}
+		since := snap.CommandSentAt
+		if since.IsZero() {
+			last, err := snap.State.LastCommunicationTime()
+			if err != nil {
+				stuck = append(stuck, snap)
+				continue
+			}
+			since = last
+		}
+		if now.Sub(since) > threshold {
+			stuck = append(stuck, snap)
+		}
+	}
+	return stuck
+}
+
+// recordCommandSent notes that MELCloud accepted a command for deviceID, for StuckCommands.
+func (c *Client) recordCommandSent(deviceID int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.commandsSent == nil {
+		c.commandsSent = make(map[int]time.Time)
+	}
+	c.commandsSent[deviceID] = c.now()
+}
+
+// commandSentAt returns when a command was last sent to deviceID, or the zero time.
+func (c *Client) commandSentAt(deviceID int) time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.commandsSent[deviceID]
+}
+
+// PendingCommands takes a Snapshot and returns the devices whose commands have been
+// pending for longer than threshold. See StuckCommands.
+func (c *Client) PendingCommands(ctx context.Context, threshold time.Duration) ([]DeviceSnapshot, error) {
+	snapshots, err := c.Snapshot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return StuckCommands(snapshots, threshold, c.now()), nil
+}
+
+// forEach calls fn for every index in [0, n) using at most maxConcurrency goroutines,
+// and returns once all calls have finished.
+func (c *Client) forEach(n int, fn func(i int)) {
+	sem := make(chan struct{}, c.maxConcurrency)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			fn(i)
+		}(i)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,140 @@
+package melcloud
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// ErrUnknownField is returned with WithStrictJSON when a response contains fields the
+// target type does not model.
+var ErrUnknownField = errors.New("unknown JSON field")
+
+// rawMessageType is skipped when looking for unknown fields: its content is kept as is.
+var rawMessageType = reflect.TypeOf(json.RawMessage(nil))
+
+// nestedObjects lists types whose UnmarshalJSON merges a nested object into the same struct,
+// keyed by the name of that object (see Device.UnmarshalJSON).
+var nestedObjects = map[reflect.Type]string{
+	reflect.TypeOf(Device{}): "Device",
+}
+
+// checkUnknownFields returns an ErrUnknownField error naming every field of data that out
+// does not model, as dotted paths (e.g. "Structure.Devices.NewField"). Unlike
+// json.Decoder.DisallowUnknownFields, it also covers types with their own UnmarshalJSON.
+func checkUnknownFields(data []byte, out interface{}) error {
+	t := reflect.TypeOf(out)
+	switch out := out.(type) {
+	case *rawState:
+		t = reflect.TypeOf(out.state)
+	case *quickState:
+		return nil // Deliberately decodes a few fields only
+	}
+
+	var unknown []string
+	collectUnknownFields(data, t, "", &unknown)
+	if len(unknown) == 0 {
+		return nil
+	}
+	slices.Sort(unknown)
+	return fmt.Errorf("%w: %s", ErrUnknownField, strings.Join(slices.Compact(unknown), ", "))
+}
+
+// collectUnknownFields walks data alongside t and appends the paths of object keys that
+// have no matching struct field. Maps, interfaces and raw messages accept any content.
+func collectUnknownFields(data []byte, t reflect.Type, path string, unknown *[]string) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == rawMessageType {
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		var obj map[string]json.RawMessage
+		if json.Unmarshal(data, &obj) != nil {
+			return // null or a value the decoder already rejected
+		}
+		fields := jsonFields(t)
+		for key, value := range obj {
+			keyPath := key
+			if path != "" {
+				keyPath = path + "." + key
+			}
+			if key == nestedObjects[t] {
+				collectUnknownFields(value, t, keyPath, unknown)
+				continue
+			}
+			ft, ok := lookupField(fields, key)
+			if !ok {
+				*unknown = append(*unknown, keyPath)
+				continue
+			}
+			collectUnknownFields(value, ft, keyPath, unknown)
+		}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return
+		}
+		var items []json.RawMessage
+		if json.Unmarshal(data, &items) != nil {
+			return
+		}
+		for _, item := range items {
+			collectUnknownFields(item, t.Elem(), path, unknown)
+		}
+	}
+}
+
+// jsonFields maps the JSON names of t's fields to their types, including fields promoted
+// from embedded structs, the way encoding/json sees them.
+func jsonFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+
+		ft := f.Type
+		if ft.Kind() == reflect.Pointer {
+			ft = ft.Elem()
+		}
+		if f.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+			for n, typ := range jsonFields(ft) {
+				if _, ok := fields[n]; !ok {
+					fields[n] = typ
+				}
+			}
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields[name] = f.Type
+	}
+	return fields
+}
+
+// lookupField finds key in fields, falling back to the case-insensitive match
+// encoding/json also accepts.
+func lookupField(fields map[string]reflect.Type, key string) (reflect.Type, bool) {
+	if t, ok := fields[key]; ok {
+		return t, true
+	}
+	for name, t := range fields {
+		if strings.EqualFold(name, key) {
+			return t, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,33 @@
+package melcloud
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Summary renders the state as a one-line description for logs and CLI output, e.g.
+// "Living Room: ON, cool 22.0°C (room 24.5°C), fan auto, vane V:swing H:auto".
+func (s *AtaDeviceState) Summary() string {
+	name := s.DeviceName
+	if name == "" {
+		name = fmt.Sprintf("Device %d", s.DeviceID)
+	}
+
+	summary := fmt.Sprintf("%s: %s, %s %.1f°C (room %.1f°C), fan %s, vane V:%s H:%s",
+		name,
+		strings.ToUpper(s.PowerState()),
+		s.OperationModeString(),
+		s.SetTemperature,
+		s.RoomTemperature,
+		s.FanSpeedString(),
+		s.VaneVerticalString(),
+		s.VaneHorizontalString(),
+	)
+	if s.IsDefrosting() {
+		summary += ", defrosting"
+	}
+	if fault := s.FaultDescription(); fault != "" {
+		summary += fmt.Sprintf(", error %d: %s", s.ErrorCode, fault)
+	}
+	return summary
+}
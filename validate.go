@@ -0,0 +1,96 @@
+package melcloud
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Validate checks the fields flagged in EffectiveFlags against the device's capabilities
+// and lock-outs, and returns every problem found rather than stopping at the first one.
+// It checks the operation mode, the setpoint range for the mode, fan speed bounds, vane
+// support and positions, and the prohibit flags. A nil result means the command is
+// expected to apply in full. dev should come from ListDevices; with a nil dev only the
+// values themselves are checked.
+func (s *AtaDeviceState) Validate(dev *Device) []error {
+	var errs []error
+	flagged := func(flag int) bool { return s.EffectiveFlags&flag != 0 }
+
+	var controls Controls
+	if dev != nil {
+		controls = dev.WritableControls()
+	}
+
+	if flagged(FlagPower) && dev != nil && !controls.Power {
+		errs = append(errs, errors.New("power is locked on this device"))
+	}
+
+	if flagged(FlagOperationMode) {
+		mode, known := opModeIntToString[s.OperationMode]
+		switch {
+		case !known:
+			errs = append(errs, fmt.Errorf("invalid operation mode %d", s.OperationMode))
+		case dev != nil && !slices.Contains(dev.SupportedModes(), mode):
+			errs = append(errs, fmt.Errorf("device does not support %s mode (supported: %s)", mode, strings.Join(dev.SupportedModes(), ", ")))
+		}
+		if dev != nil && !controls.Mode {
+			errs = append(errs, errors.New("operation mode is locked on this device"))
+		}
+	}
+
+	if flagged(FlagTargetTemp) {
+		if s.SetTemperature == 0 && (dev == nil || !dev.allowsZeroSetpoint(s.OperationMode)) {
+			errs = append(errs, ErrZeroSetTemperature)
+		} else if dev != nil {
+			if min, max, ok := dev.TemperatureRange(s.OperationMode); ok && (s.SetTemperature < min || s.SetTemperature > max) {
+				errs = append(errs, fmt.Errorf("target temperature %.1f outside range %.1f-%.1f for %s mode", s.SetTemperature, min, max, s.OperationModeString()))
+			}
+		}
+		if dev != nil && !controls.Temp {
+			errs = append(errs, errors.New("target temperature is locked on this device"))
+		}
+	}
+
+	if flagged(FlagFanSpeed) {
+		switch {
+		case s.SetFanSpeed < 0:
+			errs = append(errs, fmt.Errorf("invalid fan speed %d", s.SetFanSpeed))
+		case dev == nil:
+		case !controls.FanSpeed:
+			errs = append(errs, errors.New("device does not support setting the fan speed"))
+		default:
+			min, max, auto := dev.FanSpeedBounds()
+			if s.SetFanSpeed == FanSpeedAuto && !auto {
+				errs = append(errs, errors.New("device does not support automatic fan speed"))
+			} else if s.SetFanSpeed != FanSpeedAuto && max > 0 && (s.SetFanSpeed < min || s.SetFanSpeed > max) {
+				errs = append(errs, fmt.Errorf("fan speed %d outside supported range %d-%d", s.SetFanSpeed, min, max))
+			}
+		}
+	}
+
+	if flagged(FlagVaneVertical) {
+		_, preset := vaneVertIntToString[s.VaneVertical]
+		angle := false
+		if dev != nil {
+			_, angle = s.VaneVerticalAngle(dev)
+		}
+		if !preset && !angle {
+			errs = append(errs, fmt.Errorf("invalid vertical vane position %d", s.VaneVertical))
+		}
+		if dev != nil && !controls.VaneV {
+			errs = append(errs, errors.New("device does not support setting the vertical vane"))
+		}
+	}
+
+	if flagged(FlagVaneHorizontal) {
+		if _, ok := vaneHorizIntToString[s.VaneHorizontal]; !ok {
+			errs = append(errs, fmt.Errorf("invalid horizontal vane position %d", s.VaneHorizontal))
+		}
+		if dev != nil && !controls.VaneH {
+			errs = append(errs, errors.New("device does not support setting the horizontal vane"))
+		}
+	}
+
+	return errs
+}
@@ -0,0 +1,36 @@
+package melcloud
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// WaitFor polls a device's state every poll interval until cond returns true, and returns
+// the state that satisfied it. Polling is subject to the client's request pacing, and the
+// interval is randomized with WithPollJitter.
+// It stops with ctx's error when the context is done, returning the last state read, and
+// stops immediately if a state fetch fails. poll must be positive.
+func (c *Client) WaitFor(ctx context.Context, deviceID, buildingID int, poll time.Duration, cond func(*AtaDeviceState) bool) (*AtaDeviceState, error) {
+	if poll <= 0 {
+		return nil, fmt.Errorf("invalid poll interval: %v (must be positive)", poll)
+	}
+	var last *AtaDeviceState
+	for {
+		state, err := c.GetDeviceStateContext(ctx, deviceID, buildingID)
+		if err != nil {
+			if ctx.Err() != nil {
+				return last, ctx.Err()
+			}
+			return last, err
+		}
+		last = state
+		if cond(state) {
+			return state, nil
+		}
+
+		if err := sleepContext(ctx, poll+c.jitter()); err != nil {
+			return last, err
+		}
+	}
+}